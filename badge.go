@@ -4,18 +4,23 @@
 package watch
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	htemplate "html/template"
 	"io"
 	"log"
+	"path"
+	"regexp"
 	"strings"
 	ttemplate "text/template"
 	"time"
+	"unicode/utf8"
 
-	"cloud.google.com/go/storage"
 	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -53,107 +58,515 @@ const (
 type badgeInfo struct {
 	Text   string // text to render
 	FG, BG string // foreground (text) and background colors as "#rgb" or "#rrggbb"
-	Width  int    // width of portion in pixels
+	Width  int    // width of portion in pixels, computed from Text by CreateBadge
 }
 
 func (b badgeInfo) Center() int { return b.Width / 2 }
 
 // badgeLeft describes how the left side of the badge should be rendered.
-var badgeLeft = badgeInfo{"build", "#fff", "#555", -1 /* inferred from status width */}
+var badgeLeft = badgeInfo{Text: "build", FG: "#fff", BG: "#555"}
 
 // badgeStatuses defines how the right side of the badge should be rendered for different
 // build statuses. Statuses not listed here do not result in badge updates.
 var badgeStatuses = map[cbpb.Build_Status]badgeInfo{
-	cbpb.Build_SUCCESS:        {"success", "#fff", "#2da44e", 52},
-	cbpb.Build_FAILURE:        {"failure", "#fff", "#c62828", 52},
-	cbpb.Build_INTERNAL_ERROR: {"error", "#000", "#ffeb3b", 52},
-	cbpb.Build_TIMEOUT:        {"timeout", "#fff", "#333", 52},
+	cbpb.Build_SUCCESS:        {Text: "success", FG: "#fff", BG: "#2da44e"},
+	cbpb.Build_FAILURE:        {Text: "failure", FG: "#fff", BG: "#c62828"},
+	cbpb.Build_INTERNAL_ERROR: {Text: "error", FG: "#000", BG: "#ffeb3b"},
+	cbpb.Build_TIMEOUT:        {Text: "timeout", FG: "#fff", BG: "#333"},
+
+	// The following statuses are only displayed if BADGE_IN_PROGRESS is set.
+	// Cancelled and expired builds are only displayed if there's no earlier build to restore.
+	cbpb.Build_QUEUED:    {Text: "running", FG: "#fff", BG: "#0969da"},
+	cbpb.Build_WORKING:   {Text: "running", FG: "#fff", BG: "#0969da"},
+	cbpb.Build_CANCELLED: {Text: "cancelled", FG: "#fff", BG: "#6e7781"},
+	cbpb.Build_EXPIRED:   {Text: "expired", FG: "#fff", BG: "#6e7781"},
+}
+
+// buildRunning returns true if st indicates that a build hasn't finished yet.
+func buildRunning(st cbpb.Build_Status) bool {
+	return st == cbpb.Build_QUEUED || st == cbpb.Build_WORKING
+}
+
+// buildAbandoned returns true if st indicates that a build ended without a result.
+func buildAbandoned(st cbpb.Build_Status) bool {
+	return st == cbpb.Build_CANCELLED || st == cbpb.Build_EXPIRED
+}
+
+// badgeNotifier implements notifier by writing badge images.
+type badgeNotifier struct{ cfg *Config }
+
+func (n *badgeNotifier) name() string                        { return "badge" }
+func (n *badgeNotifier) check(b *cbpb.Build, ev Event) error { return n.cfg.checkBadge(b, ev) }
+func (n *badgeNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	return writeBadge(ctx, n.cfg, b)
+}
+
+// Metadata keys used to record which build a badge object describes.
+const (
+	badgeBuildIDKey     = "build-id"
+	badgeBuildTimeKey   = "build-create-time"
+	badgeBuildStatusKey = "build-status"
+	badgeBuildStartKey  = "build-start-time"
+
+	// badgeLastPrefix is prepended to the above keys to record the last finished build
+	// while an object describes a running build.
+	badgeLastPrefix = "last-"
+)
+
+// setBadgeMetadata sets the keys in m with the supplied prefix to describe b.
+func setBadgeMetadata(m map[string]string, prefix string, b *cbpb.Build) {
+	m[prefix+badgeBuildIDKey] = b.Id
+	m[prefix+badgeBuildTimeKey] = b.CreateTime.AsTime().UTC().Format(time.RFC3339Nano)
+	m[prefix+badgeBuildStatusKey] = b.Status.String()
+	if b.StartTime != nil {
+		m[prefix+badgeBuildStartKey] = b.StartTime.AsTime().UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// metadataBuild returns the build described by the keys in m with the supplied prefix,
+// or nil if there isn't one. The trigger and substitutions are copied from tmpl.
+func metadataBuild(m map[string]string, prefix string, tmpl *cbpb.Build) *cbpb.Build {
+	id := m[prefix+badgeBuildIDKey]
+	st, ok := cbpb.Build_Status_value[m[prefix+badgeBuildStatusKey]]
+	if id == "" || !ok {
+		return nil
+	}
+	b := &cbpb.Build{
+		Id:             id,
+		Status:         cbpb.Build_Status(st),
+		ProjectId:      tmpl.ProjectId,
+		BuildTriggerId: tmpl.BuildTriggerId,
+		Substitutions:  tmpl.Substitutions,
+	}
+	if t, err := time.Parse(time.RFC3339Nano, m[prefix+badgeBuildTimeKey]); err == nil {
+		b.CreateTime = tspb.New(t)
+	}
+	if t, err := time.Parse(time.RFC3339Nano, m[prefix+badgeBuildStartKey]); err == nil {
+		b.StartTime = tspb.New(t)
+	}
+	return b
 }
 
 // writeBadge writes a badge image describing build per cfg.
 // cfg.checkBadge must be called first to check that a badge should actually be written.
+// Objects describing newer builds are not overwritten.
 func writeBadge(ctx context.Context, cfg *Config, build *cbpb.Build) error {
 	if build.BuildTriggerId == "" {
 		return errors.New("no build trigger ID")
 	}
 
-	name := build.BuildTriggerId + ".svg"
-	log.Printf("Writing badge %v to bucket %v", name, cfg.badgeBucket)
+	idName := build.BuildTriggerId + ".svg"
+	mainName := idName // trigger-level badge listed in the index
+	var names []string
+	if cfg.badgeObjectTemplate == nil || cfg.badgeKeepIDName {
+		names = append(names, idName)
+	}
+	if cfg.badgeObjectTemplate != nil {
+		name, err := badgeObjectName(cfg.badgeObjectTemplate, build)
+		if err != nil {
+			return fmt.Errorf("badge name: %v", err)
+		}
+		if name != idName || !cfg.badgeKeepIDName {
+			names = append(names, name)
+		}
+		mainName = name
+	}
+	if cfg.badgeBranchTemplate != nil && buildSub(build, branchSub, "") != "" {
+		name, err := badgeObjectName(cfg.badgeBranchTemplate, build)
+		if err != nil {
+			return fmt.Errorf("branch badge name: %v", err)
+		}
+		names = append(names, name)
+	}
 
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return err
+	finished := !buildRunning(build.Status) && !buildAbandoned(build.Status)
+
+	type badgeObject struct {
+		name, contentType string
+		build             *cbpb.Build // build described by object, or nil for the current one
+		create            func(w io.Writer, b *cbpb.Build) error
 	}
+	var objs []badgeObject
+	for _, name := range names {
+		base := strings.TrimSuffix(name, path.Ext(name))
+		var entries []historyEntry // recent builds, oldest first
+		if cfg.badgeHistory != nil && finished {
+			var err error
+			if entries, err = appendHistory(ctx, cfg.badgeHistory, base+".json", build,
+				cfg.badgeHistorySize); err != nil {
+				return fmt.Errorf("history: %v", err)
+			}
+		}
 
-	w := client.Bucket(cfg.badgeBucket).Object(name).NewWriter(ctx)
-	w.ContentType = "image/svg+xml"
-	w.CacheControl = badgeCacheControl
-	if err := CreateBadge(w, build); err != nil {
-		return err
-	} else if err := w.Close(); err != nil {
-		return err
+		objs = append(objs, badgeObject{name, "image/svg+xml", nil,
+			func(w io.Writer, b *cbpb.Build) error { return CreateBadge(w, cfg, b) }})
+		if cfg.badgePNG {
+			objs = append(objs, badgeObject{base + ".png", "image/png", nil,
+				func(w io.Writer, b *cbpb.Build) error { return CreateBadgePNG(w, cfg, b) }})
+		}
+		// Reports describe finished builds, so they're left alone while builds are in progress.
+		if cfg.badgeReports && finished {
+			var recent []historyEntry
+			if cfg.badgeReportHist {
+				recent = entries
+			}
+			objs = append(objs, badgeObject{base + ".html", "text/html; charset=UTF-8", nil,
+				func(w io.Writer, b *cbpb.Build) error { return createReport(w, b, recent) }})
+		}
+		if cfg.badgeEndpoint {
+			objs = append(objs, badgeObject{base + ".json", "application/json", nil,
+				func(w io.Writer, b *cbpb.Build) error { return createEndpoint(w, cfg, b) }})
+		}
+		if cfg.badgeDuration && finished && build.StartTime != nil && build.FinishTime != nil {
+			objs = append(objs, badgeObject{base + "-duration.svg", "image/svg+xml", nil,
+				func(w io.Writer, b *cbpb.Build) error { return createDurationBadge(w, cfg, b) }})
+		}
+		if cfg.badgeTrend && len(entries) > 0 {
+			// Describe the newest build so that late-arriving older builds still update the image.
+			newest := entries[len(entries)-1].build(build)
+			objs = append(objs, badgeObject{base + "-trend.svg", "image/svg+xml", newest,
+				func(w io.Writer, b *cbpb.Build) error { return createTrend(w, cfg, entries) }})
+		}
 	}
 
-	if cfg.badgeReports {
-		rname := build.BuildTriggerId + ".html"
-		w := client.Bucket(cfg.badgeBucket).Object(rname).NewWriter(ctx)
-		w.ContentType = "text/html; charset=UTF-8"
-		w.CacheControl = badgeCacheControl
-		if err := CreateReport(w, build); err != nil {
-			return err
-		} else if err := w.Close(); err != nil {
+	for _, o := range objs {
+		log.Printf("Writing %v to bucket %v", o.name, cfg.badgeBucket)
+		ob := o.build
+		if ob == nil {
+			ob = build
+		}
+		if err := writeBadgeObject(ctx, cfg.badgeStore, o.name, ob, o.contentType, o.create); err != nil {
 			return err
 		}
 	}
+
+	if cfg.badgeIndex && finished {
+		if err := updateBadgeIndex(ctx, cfg, build, mainName); err != nil {
+			return fmt.Errorf("index: %v", err)
+		}
+	}
+	return nil
+}
+
+// badgeNameData is passed to templates that generate badge object names.
+// Values are sanitized by sanitizeObjectPart.
+type badgeNameData struct {
+	TriggerID   string
+	TriggerName string
+	Branch      string
+	Repo        string
+	Project     string
+}
+
+// sampleBadgeNameData is used to check badge name templates.
+var sampleBadgeNameData = badgeNameData{
+	TriggerID:   "01234567-89ab-cdef-0123-456789abcdef",
+	TriggerName: "my-trigger",
+	Branch:      "main",
+	Repo:        "my-repo",
+	Project:     "my-project",
+}
+
+// parseBadgeNameTemplate parses s as a template for generating badge object names.
+// An error is returned if s uses unknown fields or doesn't produce a valid object name.
+func parseBadgeNameTemplate(s string) (*ttemplate.Template, error) {
+	tmpl, err := ttemplate.New("").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, sampleBadgeNameData); err != nil {
+		return nil, err
+	}
+	if err := checkObjectName(b.String()); err != nil {
+		return nil, err
+	}
+	if path.Ext(b.String()) == "" {
+		return nil, fmt.Errorf("%q has no extension", b.String())
+	}
+	return tmpl, nil
+}
+
+// badgeObjectName executes tmpl with a badgeNameData describing build.
+func badgeObjectName(tmpl *ttemplate.Template, build *cbpb.Build) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, badgeNameData{
+		TriggerID:   sanitizeObjectPart(build.BuildTriggerId),
+		TriggerName: sanitizeObjectPart(buildSub(build, triggerNameSub, "")),
+		Branch:      sanitizeObjectPart(buildSub(build, branchSub, "")),
+		Repo:        sanitizeObjectPart(buildSub(build, repoSub, "")),
+		Project:     sanitizeObjectPart(build.ProjectId),
+	}); err != nil {
+		return "", err
+	}
+	name := b.String()
+	if err := checkObjectName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// checkObjectName returns an error if name isn't a valid Cloud Storage object name.
+// See https://cloud.google.com/storage/docs/objects#naming.
+func checkObjectName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("empty object name")
+	case len(name) > 1024:
+		return fmt.Errorf("object name %q is longer than 1024 bytes", name)
+	case !utf8.ValidString(name):
+		return fmt.Errorf("object name %q isn't valid UTF-8", name)
+	case strings.ContainsAny(name, "\r\n"):
+		return fmt.Errorf("object name %q contains a carriage return or line feed", name)
+	case name == "." || name == "..":
+		return fmt.Errorf("object name %q is reserved", name)
+	case strings.HasPrefix(name, ".well-known/acme-challenge/"):
+		return fmt.Errorf("object name %q starts with reserved prefix", name)
+	case strings.HasSuffix(name, "/"):
+		return fmt.Errorf("object name %q ends with a slash", name)
+	}
 	return nil
 }
 
-// CreateBadge creates an SVG badge image for build and writes it to w.
-func CreateBadge(w io.Writer, build *cbpb.Build) error {
-	right, ok := badgeStatuses[build.Status]
+// objectPartRegexp matches runs of characters that are replaced by sanitizeObjectPart.
+var objectPartRegexp = regexp.MustCompile(`[^-_.A-Za-z0-9]+`)
+
+// sanitizeObjectPart returns a version of s that can be safely used as a single component
+// of a Cloud Storage object path, e.g. "release/1.0" becomes "release-1.0".
+func sanitizeObjectPart(s string) string {
+	s = objectPartRegexp.ReplaceAllString(s, "-")
+	if s == "." || s == ".." {
+		s = strings.Repeat("-", len(s))
+	}
+	return s
+}
+
+// writeBadgeObject uses create to write an object describing build to name in st.
+// Generation preconditions are used to avoid overwriting an object that describes a build
+// created after build, even if another instance of the function is writing concurrently.
+// While build is running, the object also records the last finished build that it described
+// so that it can be restored if build is cancelled or expires.
+func writeBadgeObject(ctx context.Context, st objectStore, name string, build *cbpb.Build,
+	contentType string, create func(w io.Writer, b *cbpb.Build) error) error {
+	created := build.CreateTime.AsTime().UTC()
+	return updateObject(ctx, st, name, func(old *object) (*object, error) {
+		var oldMeta map[string]string
+		if old != nil {
+			oldMeta = old.metadata
+		}
+		oldStatus := cbpb.Build_Status(cbpb.Build_Status_value[oldMeta[badgeBuildStatusKey]])
+		sameBuild := old != nil && oldMeta[badgeBuildIDKey] == build.Id
+		newer := false // true if old describes a newer build
+		if old != nil && !sameBuild {
+			if t, err := time.Parse(time.RFC3339Nano, oldMeta[badgeBuildTimeKey]); err == nil && t.After(created) {
+				newer = true
+			}
+		}
+
+		render := build      // build to describe in the object
+		var last *cbpb.Build // last finished build, recorded if build is running
+		switch {
+		case buildAbandoned(build.Status):
+			// Restore the last finished build if the object is showing this one as running.
+			if !sameBuild {
+				return nil, nil
+			}
+			if prev := metadataBuild(oldMeta, badgeLastPrefix, build); prev != nil {
+				log.Printf("Restoring %v to build %v", name, prev.Id)
+				render = prev
+			}
+		case newer:
+			// If the newer build is still running, remember this one in case it's cancelled.
+			if buildRunning(oldStatus) && !buildRunning(build.Status) {
+				prev := metadataBuild(oldMeta, badgeLastPrefix, build)
+				if prev == nil || created.After(prev.CreateTime.AsTime()) {
+					obj := *old
+					obj.metadata = make(map[string]string, len(oldMeta))
+					for k, v := range oldMeta {
+						obj.metadata[k] = v
+					}
+					setBadgeMetadata(obj.metadata, badgeLastPrefix, build)
+					return &obj, nil
+				}
+			}
+			log.Printf("Not overwriting %v for newer build %v", name, oldMeta[badgeBuildIDKey])
+			return nil, nil
+		case buildRunning(build.Status) && old != nil:
+			if sameBuild && !buildRunning(oldStatus) {
+				log.Printf("Not overwriting %v with earlier status %v", name, build.Status)
+				return nil, nil
+			}
+			if buildRunning(oldStatus) {
+				last = metadataBuild(oldMeta, badgeLastPrefix, build)
+			} else if !buildAbandoned(oldStatus) {
+				last = metadataBuild(oldMeta, "", build)
+			}
+		}
+
+		var b bytes.Buffer
+		if err := create(&b, render); err != nil {
+			return nil, err
+		}
+		obj := &object{
+			data:         b.Bytes(),
+			contentType:  contentType,
+			cacheControl: badgeCacheControl,
+			metadata:     make(map[string]string),
+		}
+		setBadgeMetadata(obj.metadata, "", render)
+		if last != nil {
+			setBadgeMetadata(obj.metadata, badgeLastPrefix, last)
+		}
+		return obj, nil
+	})
+}
+
+// badgeAppearance returns the theme and style from cfg, or defaults if cfg is nil.
+func badgeAppearance(cfg *Config) (*badgeTheme, *badgeStyle) {
+	theme, style := defaultBadgeTheme, badgeStyles[defaultBadgeStyle]
+	if cfg != nil && cfg.badgeTheme != nil {
+		theme = cfg.badgeTheme
+	}
+	if cfg != nil && cfg.badgeStyle != nil {
+		style = cfg.badgeStyle
+	}
+	return theme, style
+}
+
+// badgeLayout returns the text, colors, and widths of the sections of build's badge,
+// along with the style that should be used to draw it. If cfg is nil, defaults are used.
+func badgeLayout(cfg *Config, build *cbpb.Build) (left, right badgeInfo, style *badgeStyle, err error) {
+	theme, style := badgeAppearance(cfg)
+	left, right, ok := theme.info(build)
 	if !ok {
-		return fmt.Errorf("no badge info defined for status %q", build.Status)
+		return left, right, nil, fmt.Errorf("no badge info defined for status %q", build.Status)
+	}
+	for _, info := range []*badgeInfo{&left, &right} {
+		info.Text = style.text(info.Text)
+		info.Width = style.sectionWidth(info.Text)
 	}
-	left := badgeLeft
-	left.Width = 90 /* from badgeTemplate */ - right.Width
+	return left, right, style, nil
+}
 
+// CreateBadge creates an SVG badge image for build per cfg and writes it to w.
+// If cfg is nil, the default badge text, colors, and style are used.
+func CreateBadge(w io.Writer, cfg *Config, build *cbpb.Build) error {
+	left, right, style, err := badgeLayout(cfg, build)
+	if err != nil {
+		return err
+	}
+
+	return renderBadge(w, left, right, style, buildRunning(build.Status),
+		build.StartTime.AsTime().UTC().Format(badgeTimeLayout))
+}
+
+// renderBadge writes an SVG badge image with the supplied sections to w.
+// The sections' widths must already be set. running animates the right section,
+// and hover is displayed when the pointer is over the badge.
+func renderBadge(w io.Writer, left, right badgeInfo, style *badgeStyle, running bool, hover string) error {
 	tmpl, err := ttemplate.New("").Parse(strings.TrimSpace(badgeTemplate))
 	if err != nil {
 		return err
 	}
 	return tmpl.Execute(w, struct {
 		Left, Right badgeInfo
-		Date        string
+		Style       *badgeStyle
+		Running     bool // animate the right side
+		Width       int  // total width
+		Center      int  // horizontal center
+		Hover       string
 	}{
-		Left:  left,
-		Right: right,
-		Date:  build.StartTime.AsTime().UTC().Format(badgeTimeLayout),
+		Left:    left,
+		Right:   right,
+		Style:   style,
+		Running: running,
+		Width:   left.Width + right.Width,
+		Center:  (left.Width + right.Width) / 2,
+		Hover:   hover,
 	})
 }
 
 const badgeTemplate = `
-<svg xmlns="http://www.w3.org/2000/svg" width="90" height="20">
-  <g font-family="DejaVu Sans,Verdana,Geneva,sans-serif" text-anchor="middle" font-size="10">
-    <rect width="90" height="20" rx="3" fill="{{.Left.BG}}" />
-    <text x="{{.Left.Center}}" y="14" fill="{{.Left.FG}}">{{.Left.Text}}</text>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Style.Height}}">
+  {{- if .Style.Gradient}}
+  <linearGradient id="gradient" x2="0" y2="100%">
+    <stop offset="0" stop-color="#fff" stop-opacity=".7" />
+    <stop offset=".1" stop-color="#aaa" stop-opacity=".1" />
+    <stop offset=".9" stop-color="#000" stop-opacity=".3" />
+    <stop offset="1" stop-color="#000" stop-opacity=".5" />
+  </linearGradient>
+  {{- end}}
+  <g font-family="Verdana,Geneva,DejaVu Sans,sans-serif" text-anchor="middle" font-size="{{.Style.FontSize}}"
+    {{- if .Style.Bold}} font-weight="bold"{{end}}
+    {{- if .Style.LetterSpacing}} letter-spacing="{{.Style.LetterSpacing}}"{{end}}>
+    <rect width="{{.Width}}" height="{{.Style.Height}}" rx="{{.Style.Radius}}" fill="{{.Left.BG}}" />
+    <text x="{{.Left.Center}}" y="{{.Style.TextY}}" fill="{{.Left.FG}}">{{.Left.Text | html}}</text>
     <g transform="translate({{.Left.Width}},0)">
-      <rect width="{{.Right.Width}}" height="20" rx="3" fill="{{.Right.BG}}" />
-      <path d="M0 0h4v20h-4z" fill="{{.Right.BG}}" />
-      <text x="{{.Right.Center}}" y="14" fill="{{.Right.FG}}">{{.Right.Text}}</text>
+      {{- if .Running}}
+      <animate attributeName="opacity" values="1;.6;1" dur="2s" repeatCount="indefinite" />
+      {{- end}}
+      <rect width="{{.Right.Width}}" height="{{.Style.Height}}" rx="{{.Style.Radius}}" fill="{{.Right.BG}}" />
+      <path d="M0 0h{{.Style.Radius}}v{{.Style.Height}}h-{{.Style.Radius}}z" fill="{{.Right.BG}}" />
+      <text x="{{.Right.Center}}" y="{{.Style.TextY}}" fill="{{.Right.FG}}">{{.Right.Text | html}}</text>
     </g>
-    <rect width="90" height="20" rx="3" fill="#555" opacity="0">
+    {{- if .Style.Gradient}}
+    <rect width="{{.Width}}" height="{{.Style.Height}}" rx="{{.Style.Radius}}" fill="url(#gradient)" />
+    {{- end}}
+    <rect width="{{.Width}}" height="{{.Style.Height}}" rx="{{.Style.Radius}}" fill="#555" opacity="0">
       <set attributeName="opacity" to="1" begin="over.mouseover" end="over.mouseout" />
     </rect>
-    <text x="45" y="14" fill="#fff" opacity="0">{{.Date}}
+    <text x="{{.Center}}" y="{{.Style.TextY}}" fill="#fff" opacity="0">{{.Hover}}
       <set attributeName="opacity" to="1" begin="over.mouseover" end="over.mouseout" />
     </text>
-    <rect id="over" width="90" height="20" opacity="0" />
+    <rect id="over" width="{{.Width}}" height="{{.Style.Height}}" opacity="0" />
   </g>
 </svg>
 `
 
+// endpointData is the shields.io endpoint schema described at https://shields.io/badges/endpoint-badge.
+type endpointData struct {
+	SchemaVersion int    `json:"schemaVersion"` // always 1
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	LabelColor    string `json:"labelColor,omitempty"`
+}
+
+// createEndpoint writes a JSON object in the shields.io endpoint schema describing build to w.
+// The text and colors match the ones used by CreateBadge.
+func createEndpoint(w io.Writer, cfg *Config, build *cbpb.Build) error {
+	theme, _ := badgeAppearance(cfg)
+	left, right, ok := theme.info(build)
+	if !ok {
+		return fmt.Errorf("no badge info defined for status %q", build.Status)
+	}
+	// shields.io expects hex colors without the leading '#'.
+	return json.NewEncoder(w).Encode(endpointData{
+		SchemaVersion: 1,
+		Label:         left.Text,
+		Message:       right.Text,
+		Color:         strings.TrimPrefix(right.BG, "#"),
+		LabelColor:    strings.TrimPrefix(left.BG, "#"),
+	})
+}
+
 // CreateReport writes an HTML document with build's status and timing information to w.
 func CreateReport(w io.Writer, build *cbpb.Build) error {
+	return createReport(w, build, nil)
+}
+
+// reportHistoryRow describes a build in the report's history table.
+type reportHistoryRow struct {
+	Status   string
+	Commit   string // shortened
+	Branch   string
+	Created  string
+	Duration string
+}
+
+// createReport is like CreateReport but also lists the builds in history,
+// ordered from oldest to newest, along with their pass rate.
+func createReport(w io.Writer, build *cbpb.Build, history []historyEntry) error {
 	tmpl, err := htemplate.New("").Parse(strings.TrimSpace(reportTemplate))
 	if err != nil {
 		return err
@@ -167,12 +580,39 @@ func CreateReport(w io.Writer, build *cbpb.Build) error {
 		Start    string
 		End      string
 		Duration string
+		History  []reportHistoryRow // newest first
+		PassRate string
 	}{
 		Status:   build.Status.String(),
 		Start:    start.UTC().Format(timeFmt),
 		End:      end.UTC().Format(timeFmt),
 		Duration: formatDuration(end.Sub(start)),
 	}
+
+	var passed int
+	for i := len(history) - 1; i >= 0; i-- {
+		e := history[i]
+		if e.Status == cbpb.Build_SUCCESS.String() {
+			passed++
+		}
+		commit := e.Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		row := reportHistoryRow{
+			Status:  e.Status,
+			Commit:  commit,
+			Branch:  e.Branch,
+			Created: e.CreateTime.UTC().Format(timeFmt),
+		}
+		if d := e.duration(); d > 0 {
+			row.Duration = formatDuration(d)
+		}
+		tdata.History = append(tdata.History, row)
+	}
+	if len(history) > 0 {
+		tdata.PassRate = fmt.Sprintf("%d%% (%d of %d)", passed*100/len(history), passed, len(history))
+	}
 	return tmpl.Execute(w, tdata)
 }
 
@@ -194,6 +634,15 @@ td.left {
   font-weight: bold;
   padding-right: 1em;
 }
+table.history {
+  margin-top: 1em;
+}
+table.history th {
+  text-align: left;
+}
+table.history th, table.history td {
+  padding-right: 1em;
+}
 </style>
 </head>
 <body>
@@ -201,7 +650,18 @@ td.left {
   <tr><td class="left">Status</td><td>{{.Status}}</td></tr>
   <tr><td class="left">Start</td><td>{{.Start}}</td></tr>
   <tr><td class="left">End</td><td>{{.End}} ({{.Duration}})</td></tr>
+  {{- if .PassRate}}
+  <tr><td class="left">Pass rate</td><td>{{.PassRate}}</td></tr>
+  {{- end}}
+</table>
+{{- if .History}}
+<table class="history">
+  <tr><th>Status</th><th>Commit</th><th>Branch</th><th>Created</th><th>Duration</th></tr>
+  {{- range .History}}
+  <tr><td>{{.Status}}</td><td>{{.Commit}}</td><td>{{.Branch}}</td><td>{{.Created}}</td><td>{{.Duration}}</td></tr>
+  {{- end}}
 </table>
+{{- end}}
 </body>
 </html>
 `
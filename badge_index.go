@@ -0,0 +1,182 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	htemplate "html/template"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// badgeManifestName is the name of the object listing the triggers in the badge index.
+	badgeManifestName = "manifest.json"
+	// badgeIndexName is the name of the HTML index of all badges.
+	badgeIndexName = "index.html"
+	// badgeManifestGenKey is a metadata key recording the manifest generation used by the index.
+	badgeManifestGenKey = "manifest-gen"
+)
+
+// manifestEntry describes a trigger's latest finished build in the badge manifest.
+type manifestEntry struct {
+	TriggerID   string    `json:"triggerId"`
+	TriggerName string    `json:"triggerName,omitempty"`
+	Badge       string    `json:"badge"`            // badge object name
+	Report      string    `json:"report,omitempty"` // report object name
+	BuildID     string    `json:"buildId"`
+	Status      string    `json:"status"`
+	CreateTime  time.Time `json:"createTime"`
+	FinishTime  time.Time `json:"finishTime"`
+}
+
+// updateBadgeIndex records build in the manifest object in cfg.badgeStore and regenerates the
+// index page from it. badge is the name of the build's trigger-level badge object.
+// Generation preconditions are used so the manifest and index don't lose concurrent updates.
+func updateBadgeIndex(ctx context.Context, cfg *Config, build *cbpb.Build, badge string) error {
+	created := build.CreateTime.AsTime().UTC()
+	if err := updateObject(ctx, cfg.badgeStore, badgeManifestName, func(old *object) (*object, error) {
+		entries := make(map[string]manifestEntry) // keyed by badge name
+		if old != nil {
+			if err := json.Unmarshal(old.data, &entries); err != nil {
+				return nil, err
+			}
+		}
+		if e, ok := entries[badge]; ok && e.CreateTime.After(created) {
+			log.Printf("Not updating %v for newer build %v", badgeManifestName, e.BuildID)
+			return nil, nil
+		}
+		e := manifestEntry{
+			TriggerID:   build.BuildTriggerId,
+			TriggerName: buildSub(build, triggerNameSub, ""),
+			Badge:       badge,
+			BuildID:     build.Id,
+			Status:      build.Status.String(),
+			CreateTime:  created,
+			FinishTime:  build.FinishTime.AsTime().UTC(),
+		}
+		if cfg.badgeReports {
+			e.Report = strings.TrimSuffix(badge, path.Ext(badge)) + ".html"
+		}
+		entries[badge] = e
+
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return nil, err
+		}
+		return &object{data: data, contentType: "application/json", cacheControl: "no-cache"}, nil
+	}); err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+
+	// Read the manifest again to get its generation, since it may have been skipped above.
+	man, err := cfg.badgeStore.read(ctx, badgeManifestName)
+	if err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	var entries map[string]manifestEntry
+	if err := json.Unmarshal(man.data, &entries); err != nil {
+		return fmt.Errorf("manifest: %v", err)
+	}
+	log.Printf("Writing %v to bucket %v", badgeIndexName, cfg.badgeBucket)
+	return updateObject(ctx, cfg.badgeStore, badgeIndexName, func(old *object) (*object, error) {
+		if old != nil {
+			if gen, err := strconv.ParseInt(old.metadata[badgeManifestGenKey], 10, 64); err == nil &&
+				gen >= man.gen {
+				return nil, nil // already written from the same or a newer manifest
+			}
+		}
+		var b bytes.Buffer
+		if err := createIndex(&b, entries); err != nil {
+			return nil, err
+		}
+		return &object{
+			data:         b.Bytes(),
+			contentType:  "text/html; charset=UTF-8",
+			cacheControl: badgeCacheControl,
+			metadata:     map[string]string{badgeManifestGenKey: strconv.FormatInt(man.gen, 10)},
+		}, nil
+	})
+}
+
+// createIndex writes an HTML document listing the triggers in entries to w.
+func createIndex(w io.Writer, entries map[string]manifestEntry) error {
+	tmpl, err := htemplate.New("").Parse(strings.TrimSpace(indexTemplate))
+	if err != nil {
+		return err
+	}
+
+	type row struct {
+		Name, Badge, Report, Status, LastRun string
+	}
+	const timeFmt = time.RFC1123Z // "Mon, 02 Jan 2006 15:04:05 -0700"
+	rows := make([]row, 0, len(entries))
+	for _, e := range entries {
+		name := e.TriggerName
+		if name == "" {
+			name = e.TriggerID
+		}
+		rows = append(rows, row{
+			Name:    name,
+			Badge:   e.Badge,
+			Report:  e.Report,
+			Status:  e.Status,
+			LastRun: e.FinishTime.Format(timeFmt),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Name != rows[j].Name {
+			return rows[i].Name < rows[j].Name
+		}
+		return rows[i].Badge < rows[j].Badge
+	})
+	return tmpl.Execute(w, rows)
+}
+
+const indexTemplate = `
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Builds</title>
+<style>
+body {
+  font-family: Arial, Helvetica, sans-serif;
+}
+table {
+  border-spacing: 0;
+}
+th {
+  text-align: left;
+}
+th, td {
+  padding: 0.2em 1em 0.2em 0;
+}
+</style>
+</head>
+<body>
+<table>
+  <tr><th>Trigger</th><th>Badge</th><th>Status</th><th>Last run</th></tr>
+  {{- range .}}
+  <tr>
+    <td>{{if .Report}}<a href="{{.Report}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td>
+    <td><img src="{{.Badge}}" alt="{{.Status}}"></td>
+    <td>{{.Status}}</td>
+    <td>{{.LastRun}}</td>
+  </tr>
+  {{- end}}
+</table>
+</body>
+</html>
+`
@@ -0,0 +1,105 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestWriteBadge_Index(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+	cfg := &Config{badgeBucket: "bucket", badgeStore: st, badgeReports: true, badgeIndex: true}
+
+	for _, b := range []*cbpb.Build{
+		makeBuild("2", "trigger-a", cbpb.Build_FAILURE, "2021-12-02T00:00:00Z", triggerNameSub, "deploy"),
+		makeBuild("3", "trigger-b", cbpb.Build_SUCCESS, "2021-12-03T00:00:00Z", triggerNameSub, "build"),
+		makeBuild("1", "trigger-a", cbpb.Build_SUCCESS, "2021-12-01T00:00:00Z", triggerNameSub, "deploy"), // older
+		makeBuild("4", "trigger-b", cbpb.Build_WORKING, "2021-12-04T00:00:00Z", triggerNameSub, "build"),  // in progress
+	} {
+		b.FinishTime = b.CreateTime
+		if err := writeBadge(ctx, cfg, b); err != nil {
+			t.Fatalf("writeBadge for build %v failed: %v", b.Id, err)
+		}
+	}
+
+	obj, err := st.read(ctx, badgeManifestName)
+	if err != nil {
+		t.Fatal("Failed reading manifest: ", err)
+	}
+	var entries map[string]manifestEntry
+	if err := json.Unmarshal(obj.data, &entries); err != nil {
+		t.Fatal("Failed unmarshaling manifest: ", err)
+	}
+	for badge, want := range map[string]string{"trigger-a.svg": "2", "trigger-b.svg": "3"} {
+		if e, ok := entries[badge]; !ok {
+			t.Errorf("Manifest doesn't include %v", badge)
+		} else if e.BuildID != want {
+			t.Errorf("Manifest lists build %v for %v; want %v", e.BuildID, badge, want)
+		}
+	}
+
+	if obj, err = st.read(ctx, badgeIndexName); err != nil {
+		t.Fatal("Failed reading index: ", err)
+	}
+	index := string(obj.data)
+	if _, err := html.Parse(bytes.NewReader(obj.data)); err != nil {
+		t.Fatalf("Index isn't valid HTML: %v\n%v", err, index)
+	}
+	for _, s := range []string{
+		`<a href="trigger-a.html">deploy</a>`,
+		`<img src="trigger-a.svg" alt="FAILURE">`,
+		`<a href="trigger-b.html">build</a>`,
+		`<img src="trigger-b.svg" alt="SUCCESS">`,
+	} {
+		if !strings.Contains(index, s) {
+			t.Errorf("%q doesn't appear in index:\n%v", s, index)
+		}
+	}
+	if strings.Index(index, "trigger-b.svg") > strings.Index(index, "trigger-a.svg") {
+		t.Errorf("Triggers aren't sorted by name in index:\n%v", index)
+	}
+}
+
+func TestWriteBadge_IndexKeepIDName(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+	tmpl, err := parseBadgeNameTemplate("{{.TriggerName}}.svg")
+	if err != nil {
+		t.Fatal("parseBadgeNameTemplate failed: ", err)
+	}
+	cfg := &Config{badgeBucket: "bucket", badgeStore: st, badgeIndex: true,
+		badgeObjectTemplate: tmpl, badgeKeepIDName: true}
+	build := makeBuild("build-id", "trigger-id", cbpb.Build_SUCCESS, "2021-12-01T00:00:00Z",
+		triggerNameSub, "deploy")
+	build.FinishTime = build.CreateTime
+	if err := writeBadge(ctx, cfg, build); err != nil {
+		t.Fatal("writeBadge failed: ", err)
+	}
+
+	// The index should list the templated name rather than the one derived from the trigger ID.
+	obj, err := st.read(ctx, badgeManifestName)
+	if err != nil {
+		t.Fatal("Failed reading manifest: ", err)
+	}
+	var entries map[string]manifestEntry
+	if err := json.Unmarshal(obj.data, &entries); err != nil {
+		t.Fatal("Failed unmarshaling manifest: ", err)
+	}
+	var got []string
+	for badge := range entries {
+		got = append(got, badge)
+	}
+	if want := []string{"deploy.svg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Manifest lists %q; want %q", got, want)
+	}
+}
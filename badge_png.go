@@ -0,0 +1,209 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// badgePNGScale is the factor by which PNG badges are scaled relative to SVG badges
+// so they still look sharp on high-density displays.
+const badgePNGScale = 2
+
+var (
+	badgeFontsOnce sync.Once
+	badgeFontsErr  error
+	badgeRegular   *opentype.Font // embedded Go Regular font
+	badgeBold      *opentype.Font // embedded Go Bold font
+)
+
+// loadBadgeFonts parses the fonts embedded in the binary.
+// Verdana isn't freely redistributable, so the similar-looking Go fonts are used instead.
+func loadBadgeFonts() error {
+	badgeFontsOnce.Do(func() {
+		if badgeRegular, badgeFontsErr = opentype.Parse(goregular.TTF); badgeFontsErr != nil {
+			return
+		}
+		badgeBold, badgeFontsErr = opentype.Parse(gobold.TTF)
+	})
+	return badgeFontsErr
+}
+
+// CreateBadgePNG is like CreateBadge but writes a PNG image instead of an SVG image.
+// The image is badgePNGScale times the size of the SVG image.
+func CreateBadgePNG(w io.Writer, cfg *Config, build *cbpb.Build) error {
+	left, right, style, err := badgeLayout(cfg, build)
+	if err != nil {
+		return err
+	}
+	if err := loadBadgeFonts(); err != nil {
+		return err
+	}
+	fnt := badgeRegular
+	if style.Bold {
+		fnt = badgeBold
+	}
+	face, err := opentype.NewFace(fnt, &opentype.FaceOptions{
+		Size:    float64(style.FontSize * badgePNGScale),
+		DPI:     72, // use pixels as points
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	const sc = badgePNGScale
+	width, height := (left.Width+right.Width)*sc, style.Height*sc
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	radius := float64(style.Radius * sc)
+
+	for _, sec := range []struct {
+		info badgeInfo
+		x0   int // left edge of section
+	}{
+		{left, 0},
+		{right, left.Width * sc},
+	} {
+		bg, err := parseHexColor(sec.info.BG)
+		if err != nil {
+			return err
+		}
+		fg, err := parseHexColor(sec.info.FG)
+		if err != nil {
+			return err
+		}
+		// Round the badge's outer corners but not the ones where the sections meet.
+		fillRoundedRect(img, image.Rect(sec.x0, 0, sec.x0+sec.info.Width*sc, height), width, radius, bg)
+		drawBadgeText(img, face, sec.info.Text, fg,
+			sec.x0+sec.info.Center()*sc, style.TextY*sc, style.LetterSpacing*sc)
+	}
+	if style.Gradient {
+		drawGloss(img, width, radius)
+	}
+	return png.Encode(w, img)
+}
+
+// parseHexColor parses a color in "#rgb" or "#rrggbb" format.
+func parseHexColor(s string) (color.RGBA, error) {
+	if !colorRegexp.MatchString(s) {
+		return color.RGBA{}, fmt.Errorf("bad color %q", s)
+	}
+	s = s[1:]
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// cornerCoverage returns the fraction of the pixel with top-left corner (x, y) that lies
+// within a rectangle starting at x=0 with the supplied width, height, and corner radius.
+// Pixels are supersampled to antialias the corners.
+func cornerCoverage(x, y, width, height int, radius float64) float64 {
+	const samples = 4
+	var in int
+	for i := 0; i < samples; i++ {
+		for j := 0; j < samples; j++ {
+			px := float64(x) + (float64(i)+0.5)/samples
+			py := float64(y) + (float64(j)+0.5)/samples
+			// Find the distance from the sample to the nearest corner circle's center.
+			cx := math.Max(radius-px, px-(float64(width)-radius))
+			cy := math.Max(radius-py, py-(float64(height)-radius))
+			if cx <= 0 || cy <= 0 || cx*cx+cy*cy <= radius*radius {
+				in++
+			}
+		}
+	}
+	return float64(in) / (samples * samples)
+}
+
+// fillRoundedRect fills r within img using c. The corners of a full-height rectangle
+// of width totalWidth starting at x=0 are rounded with the supplied radius.
+func fillRoundedRect(img *image.RGBA, r image.Rectangle, totalWidth int, radius float64, c color.RGBA) {
+	height := img.Bounds().Dy()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cov := 1.0
+			if radius > 0 {
+				cov = cornerCoverage(x, y, totalWidth, height, radius)
+			}
+			if cov > 0 {
+				blend(img, x, y, c, cov)
+			}
+		}
+	}
+}
+
+// drawGloss draws a glossy vertical gradient like the one used by the "plastic" style.
+func drawGloss(img *image.RGBA, width int, radius float64) {
+	height := img.Bounds().Dy()
+	for y := 0; y < height; y++ {
+		// Approximate the SVG template's gradient stops.
+		f := (float64(y) + 0.5) / float64(height)
+		var c color.RGBA
+		var a float64
+		switch {
+		case f < 0.1:
+			c, a = color.RGBA{0xff, 0xff, 0xff, 0xff}, 0.7-6*f
+		case f < 0.9:
+			c, a = color.RGBA{0, 0, 0, 0xff}, 0.3*(f-0.1)/0.8
+		default:
+			c, a = color.RGBA{0, 0, 0, 0xff}, 0.3+2*(f-0.9)
+		}
+		for x := 0; x < width; x++ {
+			if cov := cornerCoverage(x, y, width, height, radius); cov > 0 {
+				blend(img, x, y, c, a*cov)
+			}
+		}
+	}
+}
+
+// blend blends c into img's pixel at (x, y) with the supplied opacity in [0, 1].
+func blend(img *image.RGBA, x, y int, c color.RGBA, opacity float64) {
+	// c is opaque, so the premultiplied channels can all be interpolated the same way.
+	mix := func(src, dst uint8) uint8 {
+		return uint8(math.Round(float64(src)*opacity + float64(dst)*(1-opacity)))
+	}
+	p := img.RGBAAt(x, y)
+	img.SetRGBA(x, y, color.RGBA{mix(c.R, p.R), mix(c.G, p.G), mix(c.B, p.B), mix(c.A, p.A)})
+}
+
+// drawBadgeText draws s in img using face and c. The text is horizontally centered at cx
+// and its baseline is at y. spacing is added after each character.
+func drawBadgeText(img *image.RGBA, face font.Face, s string, c color.RGBA, cx, y int, spacing float64) {
+	if s == "" {
+		return
+	}
+	sp := fixed.Int26_6(spacing * 64)
+	d := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	var width fixed.Int26_6
+	for _, r := range s {
+		width += d.MeasureString(string(r)) + sp
+	}
+	width -= sp // no spacing after the last character
+	d.Dot = fixed.Point26_6{X: fixed.I(cx) - width/2, Y: fixed.I(y)}
+	for _, r := range s {
+		d.DrawString(string(r))
+		d.Dot.X += sp
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestCreateBadgePNG(t *testing.T) {
+	for _, style := range []string{"flat", "flat-square", "plastic", "for-the-badge"} {
+		cfg, err := FakeBadgeConfig("github", "build", style)
+		if err != nil {
+			t.Fatalf("FakeBadgeConfig(%q) failed: %v", style, err)
+		}
+		build := &cbpb.Build{Status: cbpb.Build_FAILURE}
+		var b bytes.Buffer
+		if err := CreateBadgePNG(&b, cfg, build); err != nil {
+			t.Fatalf("CreateBadgePNG failed for %q: %v", style, err)
+		}
+		img, err := png.Decode(&b)
+		if err != nil {
+			t.Fatalf("Badge for %q isn't a valid PNG: %v", style, err)
+		}
+
+		left, right, bs, err := badgeLayout(cfg, build)
+		if err != nil {
+			t.Fatal("badgeLayout failed: ", err)
+		}
+		width, height := (left.Width+right.Width)*badgePNGScale, bs.Height*badgePNGScale
+		if got := img.Bounds().Size(); got.X != width || got.Y != height {
+			t.Errorf("Badge for %q is %vx%v; want %vx%v", style, got.X, got.Y, width, height)
+		}
+		if bs.Gradient {
+			continue // gradient alters background colors
+		}
+		// Check the background colors just inside each section's leading edge, halfway down.
+		for _, tc := range []struct {
+			x    int
+			want string
+		}{
+			{badgePNGScale, left.BG},
+			{left.Width*badgePNGScale + badgePNGScale, right.BG},
+		} {
+			want, _ := parseHexColor(tc.want)
+			if got := color.RGBAModel.Convert(img.At(tc.x, height/2)); got != want {
+				t.Errorf("Badge for %q has color %v at x=%v; want %v", style, got, tc.x, want)
+			}
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want color.RGBA
+		ok   bool
+	}{
+		{"#2da44e", color.RGBA{0x2d, 0xa4, 0x4e, 0xff}, true},
+		{"#fA0", color.RGBA{0xff, 0xaa, 0x00, 0xff}, true},
+		{"2da44e", color.RGBA{}, false},
+		{"#12345", color.RGBA{}, false},
+	} {
+		if got, err := parseHexColor(tc.in); err != nil && tc.ok {
+			t.Errorf("parseHexColor(%q) failed: %v", tc.in, err)
+		} else if err == nil && !tc.ok {
+			t.Errorf("parseHexColor(%q) unexpectedly succeeded", tc.in)
+		} else if got != tc.want {
+			t.Errorf("parseHexColor(%q) = %v; want %v", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// badgeStyle describes the shape and typography of a badge.
+// The names of the styles in badgeStyles match the ones used by shields.io.
+type badgeStyle struct {
+	Height        int     // badge height in pixels
+	Radius        int     // corner radius in pixels
+	Gradient      bool    // draw a glossy gradient over the badge
+	FontSize      int     // font size in pixels
+	Bold          bool    // use a bold font
+	LetterSpacing float64 // extra space in pixels after each character
+	TextY         int     // text baseline in pixels from the top of the badge
+
+	padding   int     // horizontal padding in pixels on each side of text
+	uppercase bool    // convert text to uppercase
+	scale     float64 // multiplier for Verdana widths, e.g. to approximate bold text
+}
+
+// defaultBadgeStyle is the name of the default style in badgeStyles.
+const defaultBadgeStyle = "flat"
+
+// badgeStyles contains styles that can be selected using BADGE_STYLE.
+var badgeStyles = map[string]*badgeStyle{
+	"flat": {
+		Height: 20, Radius: 3, FontSize: 11, TextY: 14, padding: 6, scale: 1,
+	},
+	"flat-square": {
+		Height: 20, Radius: 0, FontSize: 11, TextY: 14, padding: 6, scale: 1,
+	},
+	"plastic": {
+		Height: 18, Radius: 4, Gradient: true, FontSize: 11, TextY: 13, padding: 6, scale: 1,
+	},
+	"for-the-badge": {
+		Height: 28, Radius: 0, FontSize: 10, Bold: true, LetterSpacing: 1, TextY: 18,
+		padding: 9, uppercase: true, scale: 1.1, // Verdana Bold is roughly 10% wider
+	},
+}
+
+// text returns t transformed as needed for s, e.g. converted to uppercase.
+func (s *badgeStyle) text(t string) string {
+	if s.uppercase {
+		return strings.ToUpper(t)
+	}
+	return t
+}
+
+// sectionWidth returns the width in pixels of a badge section containing t,
+// which should have already been passed to text.
+func (s *badgeStyle) sectionWidth(t string) int {
+	w := textWidth(t, float64(s.FontSize))*s.scale + s.LetterSpacing*float64(utf8.RuneCountInString(t))
+	return int(math.Ceil(w)) + 2*s.padding
+}
@@ -5,18 +5,25 @@ package watch
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"encoding/xml"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"text/template"
+	"time"
 
 	"golang.org/x/net/html"
 	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestCreateBadge(t *testing.T) {
 	// Just check that the template produces valid XML that contains the status.
 	var b bytes.Buffer
-	if err := CreateBadge(&b, &cbpb.Build{Status: cbpb.Build_SUCCESS}); err != nil {
+	if err := CreateBadge(&b, nil, &cbpb.Build{Status: cbpb.Build_SUCCESS}); err != nil {
 		t.Fatal("CreateBadge failed: ", err)
 	}
 	if err := xml.Unmarshal(b.Bytes(), new(interface{})); err != nil {
@@ -45,3 +52,395 @@ func TestCreateReport(t *testing.T) {
 		t.Errorf("%q doesn't appear in report:\n%v", cbpb.Build_SUCCESS.String(), report)
 	}
 }
+
+func TestCreateEndpoint(t *testing.T) {
+	cfg, err := FakeBadgeConfig("shields", "tests", "flat")
+	if err != nil {
+		t.Fatal("FakeBadgeConfig failed: ", err)
+	}
+	var b bytes.Buffer
+	if err := createEndpoint(&b, cfg, &cbpb.Build{Status: cbpb.Build_FAILURE}); err != nil {
+		t.Fatal("createEndpoint failed: ", err)
+	}
+	var got endpointData
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("Failed unmarshaling %q: %v", b.String(), err)
+	}
+	want := endpointData{SchemaVersion: 1, Label: "tests", Message: "failure", Color: "e05d44", LabelColor: "555"}
+	if got != want {
+		t.Errorf("createEndpoint wrote %+v; want %+v", got, want)
+	}
+}
+
+func TestCreateReport_History(t *testing.T) {
+	ts := func(s string) time.Time { return makeTimestamp(s).AsTime() }
+	history := []historyEntry{
+		{BuildID: "1", Status: "FAILURE", CreateTime: ts("2021-12-01T00:00:00Z"),
+			Commit: "0123456789abcdef", Branch: "dev"},
+		{BuildID: "2", Status: "SUCCESS", CreateTime: ts("2021-12-02T00:00:00Z"),
+			StartTime: ts("2021-12-02T00:00:00Z"), FinishTime: ts("2021-12-02T00:03:41Z"),
+			Commit: "fedcba9876543210", Branch: "main"},
+	}
+	var b bytes.Buffer
+	if err := createReport(&b, &cbpb.Build{
+		Status:     cbpb.Build_SUCCESS,
+		LogUrl:     "https://example.org/log",
+		StartTime:  makeTimestamp("2021-12-02T00:00:00Z"),
+		FinishTime: makeTimestamp("2021-12-02T00:03:41Z"),
+	}, history); err != nil {
+		t.Fatal("createReport failed: ", err)
+	}
+	report := b.String()
+	if _, err := html.Parse(&b); err != nil {
+		t.Fatalf("Report isn't valid HTML: %v\n%v", err, report)
+	}
+	for _, s := range []string{"50% (1 of 2)", "0123456", "fedcba9", "dev", "main", "3m41s"} {
+		if !strings.Contains(report, s) {
+			t.Errorf("%q doesn't appear in report:\n%v", s, report)
+		}
+	}
+	for _, s := range []string{"0123456789abcdef", "example.org"} {
+		if strings.Contains(report, s) {
+			t.Errorf("%q unexpectedly appears in report:\n%v", s, report)
+		}
+	}
+	if strings.Index(report, "fedcba9") > strings.Index(report, "0123456") {
+		t.Errorf("Newest build isn't listed first in report:\n%v", report)
+	}
+}
+
+func TestWriteBadge_OutOfOrder(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+	cfg := &Config{badgeBucket: "bucket", badgeStore: st, badgeReports: true, badgeEndpoint: true}
+
+	for _, tc := range []struct {
+		build  *cbpb.Build
+		wantID string // ID of build that objects should describe afterward
+		want   string // status that badge should contain afterward
+	}{
+		{makeBuild("2", "trigger-id", cbpb.Build_FAILURE, "2021-12-02T00:00:00Z"), "2", "failure"},
+		{makeBuild("1", "trigger-id", cbpb.Build_SUCCESS, "2021-12-01T00:00:00Z"), "2", "failure"}, // older
+		{makeBuild("3", "trigger-id", cbpb.Build_SUCCESS, "2021-12-03T00:00:00Z"), "3", "success"},
+		{makeBuild("3", "trigger-id", cbpb.Build_TIMEOUT, "2021-12-03T00:00:00Z"), "3", "timeout"}, // same build
+	} {
+		if err := writeBadge(ctx, cfg, tc.build); err != nil {
+			t.Fatalf("writeBadge for build %v failed: %v", tc.build.Id, err)
+		}
+		for _, name := range []string{"trigger-id.svg", "trigger-id.html", "trigger-id.json"} {
+			obj, err := st.read(ctx, name)
+			if err != nil {
+				t.Fatalf("Failed reading %v after build %v: %v", name, tc.build.Id, err)
+			}
+			if got := obj.metadata[badgeBuildIDKey]; got != tc.wantID {
+				t.Errorf("%v describes build %v after build %v; want %v", name, got, tc.build.Id, tc.wantID)
+			}
+		}
+		if obj, err := st.read(ctx, "trigger-id.svg"); err == nil && !strings.Contains(string(obj.data), tc.want) {
+			t.Errorf("Badge after build %v doesn't contain %q:\n%s", tc.build.Id, tc.want, obj.data)
+		}
+	}
+}
+
+func TestWriteBadge_InProgress(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+	cfg := &Config{badgeBucket: "bucket", badgeStore: st, badgeReports: true, badgeRunning: true}
+
+	for _, tc := range []struct {
+		build    *cbpb.Build
+		wantID   string // ID of build that badge should describe afterward
+		want     string // status that badge should contain afterward
+		reportID string // ID of build that report should describe afterward
+	}{
+		{makeBuild("1", "trigger-id", cbpb.Build_SUCCESS, "2021-12-01T00:00:00Z"), "1", "success", "1"},
+		{makeBuild("2", "trigger-id", cbpb.Build_WORKING, "2021-12-02T00:00:00Z"), "2", "running", "1"},
+		{makeBuild("2", "trigger-id", cbpb.Build_CANCELLED, "2021-12-02T00:00:00Z"), "1", "success", "1"},
+		{makeBuild("2", "trigger-id", cbpb.Build_CANCELLED, "2021-12-02T00:00:00Z"), "1", "success", "1"}, // redelivered
+		{makeBuild("3", "trigger-id", cbpb.Build_QUEUED, "2021-12-03T00:00:00Z"), "3", "running", "1"},
+		{makeBuild("4", "trigger-id", cbpb.Build_WORKING, "2021-12-04T00:00:00Z"), "4", "running", "1"},
+		{makeBuild("3", "trigger-id", cbpb.Build_FAILURE, "2021-12-03T00:00:00Z"), "4", "running", "3"}, // older
+		{makeBuild("4", "trigger-id", cbpb.Build_EXPIRED, "2021-12-04T00:00:00Z"), "3", "failure", "3"},
+		{makeBuild("5", "trigger-id", cbpb.Build_WORKING, "2021-12-05T00:00:00Z"), "5", "running", "3"},
+		{makeBuild("5", "trigger-id", cbpb.Build_SUCCESS, "2021-12-05T00:00:00Z"), "5", "success", "5"},
+		{makeBuild("5", "trigger-id", cbpb.Build_WORKING, "2021-12-05T00:00:00Z"), "5", "success", "5"}, // out of order
+	} {
+		if err := writeBadge(ctx, cfg, tc.build); err != nil {
+			t.Fatalf("writeBadge for build %v (%v) failed: %v", tc.build.Id, tc.build.Status, err)
+		}
+		obj, err := st.read(ctx, "trigger-id.svg")
+		if err != nil {
+			t.Fatalf("Failed reading badge after build %v (%v): %v", tc.build.Id, tc.build.Status, err)
+		}
+		if got := obj.metadata[badgeBuildIDKey]; got != tc.wantID {
+			t.Errorf("Badge describes build %v after build %v (%v); want %v",
+				got, tc.build.Id, tc.build.Status, tc.wantID)
+		}
+		if !strings.Contains(string(obj.data), ">"+tc.want+"<") {
+			t.Errorf("Badge after build %v (%v) doesn't contain %q:\n%s",
+				tc.build.Id, tc.build.Status, tc.want, obj.data)
+		}
+		if animated := strings.Contains(string(obj.data), "<animate "); animated != (tc.want == "running") {
+			t.Errorf("Badge after build %v (%v) has animation %v", tc.build.Id, tc.build.Status, animated)
+		}
+		if obj, err := st.read(ctx, "trigger-id.html"); err != nil {
+			t.Errorf("Failed reading report after build %v (%v): %v", tc.build.Id, tc.build.Status, err)
+		} else if got := obj.metadata[badgeBuildIDKey]; got != tc.reportID {
+			t.Errorf("Report describes build %v after build %v (%v); want %v",
+				got, tc.build.Id, tc.build.Status, tc.reportID)
+		}
+	}
+
+	// If there's no earlier build to restore, the cancelled status should be displayed.
+	first := makeBuild("1", "other-id", cbpb.Build_WORKING, "2021-12-01T00:00:00Z")
+	for _, status := range []cbpb.Build_Status{cbpb.Build_WORKING, cbpb.Build_CANCELLED} {
+		first.Status = status
+		if err := writeBadge(ctx, cfg, first); err != nil {
+			t.Fatalf("writeBadge for %v failed: %v", status, err)
+		}
+	}
+	if obj, err := st.read(ctx, "other-id.svg"); err != nil {
+		t.Error("Failed reading badge: ", err)
+	} else if !strings.Contains(string(obj.data), ">cancelled<") {
+		t.Errorf("Badge for first cancelled build doesn't contain \"cancelled\":\n%s", obj.data)
+	}
+}
+
+func TestWriteBadge_DurationTrend(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+	cfg := &Config{
+		badgeBucket:      "bucket",
+		badgeStore:       st,
+		badgeDuration:    true,
+		badgeHistory:     newMemStore(),
+		badgeHistorySize: 3,
+		badgeTrend:       true,
+	}
+
+	for _, tc := range []struct {
+		build    *cbpb.Build
+		dur      time.Duration // time between build's start and finish
+		duration string        // expected text in duration badge
+		bars     int           // expected number of bars in trend image
+	}{
+		{makeBuild("2", "trigger-id", cbpb.Build_SUCCESS, "2021-12-02T00:00:00Z"), 3*time.Minute + 41*time.Second, "3m41s", 1},
+		{makeBuild("3", "trigger-id", cbpb.Build_FAILURE, "2021-12-03T00:00:00Z"), 45 * time.Second, "45s", 2},
+		{makeBuild("1", "trigger-id", cbpb.Build_SUCCESS, "2021-12-01T00:00:00Z"), time.Hour, "45s", 3}, // older
+		{makeBuild("4", "trigger-id", cbpb.Build_SUCCESS, "2021-12-04T00:00:00Z"), 2 * time.Minute, "2m", 3},
+	} {
+		tc.build.StartTime = tc.build.CreateTime
+		tc.build.FinishTime = tspb.New(tc.build.CreateTime.AsTime().Add(tc.dur))
+		if err := writeBadge(ctx, cfg, tc.build); err != nil {
+			t.Fatalf("writeBadge for build %v failed: %v", tc.build.Id, err)
+		}
+		if obj, err := st.read(ctx, "trigger-id-duration.svg"); err != nil {
+			t.Errorf("Failed reading duration badge after build %v: %v", tc.build.Id, err)
+		} else if !strings.Contains(string(obj.data), ">"+tc.duration+"<") {
+			t.Errorf("Duration badge after build %v doesn't contain %q:\n%s", tc.build.Id, tc.duration, obj.data)
+		}
+		if obj, err := st.read(ctx, "trigger-id-trend.svg"); err != nil {
+			t.Errorf("Failed reading trend image after build %v: %v", tc.build.Id, err)
+		} else if err := xml.Unmarshal(obj.data, new(interface{})); err != nil {
+			t.Errorf("Trend image after build %v isn't valid XML: %v", tc.build.Id, err)
+		} else if got := strings.Count(string(obj.data), "<rect "); got != tc.bars {
+			t.Errorf("Trend image after build %v has %d bar(s); want %d", tc.build.Id, got, tc.bars)
+		}
+	}
+}
+
+func TestWriteBadge_ReportHistory(t *testing.T) {
+	ctx := context.Background()
+	for _, reportHist := range []bool{false, true} {
+		st := newMemStore()
+		cfg := &Config{
+			badgeBucket:      "bucket",
+			badgeStore:       st,
+			badgeReports:     true,
+			badgeHistory:     newMemStore(),
+			badgeHistorySize: 3,
+			badgeReportHist:  reportHist,
+		}
+		build := makeBuild("build-id", "trigger-id", cbpb.Build_SUCCESS, "2021-12-02T00:00:00Z")
+		if err := writeBadge(ctx, cfg, build); err != nil {
+			t.Fatal("writeBadge failed: ", err)
+		}
+		obj, err := st.read(ctx, "trigger-id.html")
+		if err != nil {
+			t.Fatal("Failed reading report: ", err)
+		}
+		if got := strings.Contains(string(obj.data), "Pass rate"); got != reportHist {
+			t.Errorf("Report with badgeReportHist=%v has pass rate: %v\n%s", reportHist, got, obj.data)
+		}
+	}
+}
+
+func TestWriteBadge_Branch(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+	cfg := &Config{
+		badgeBucket:         "bucket",
+		badgeStore:          st,
+		badgeReports:        true,
+		badgePNG:            true,
+		badgeBranchTemplate: template.Must(template.New("").Parse("{{.TriggerName}}/{{.Branch}}.svg")),
+	}
+	build := &cbpb.Build{
+		Id:             "build-id",
+		BuildTriggerId: "trigger-id",
+		Status:         cbpb.Build_SUCCESS,
+		Substitutions:  map[string]string{triggerNameSub: "my trigger", branchSub: "release/1.0"},
+	}
+	if err := writeBadge(ctx, cfg, build); err != nil {
+		t.Fatal("writeBadge failed: ", err)
+	}
+	for _, name := range []string{
+		"trigger-id.svg",
+		"trigger-id.html",
+		"trigger-id.png",
+		"my-trigger/release-1.0.svg",
+		"my-trigger/release-1.0.html",
+		"my-trigger/release-1.0.png",
+	} {
+		if _, err := st.read(ctx, name); err != nil {
+			t.Errorf("Failed reading %v: %v", name, err)
+		}
+	}
+}
+
+func TestSanitizeObjectPart(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"main", "main"},
+		{"release/1.0", "release-1.0"},
+		{"feature/foo bar//baz", "feature-foo-bar-baz"},
+		{"my_branch-2", "my_branch-2"},
+		{"..", "--"},
+		{".", "-"},
+	} {
+		if got := sanitizeObjectPart(tc.in); got != tc.want {
+			t.Errorf("sanitizeObjectPart(%q) = %q; want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestWriteBadge_ObjectTemplate(t *testing.T) {
+	build := &cbpb.Build{
+		Id:             "build-id",
+		ProjectId:      "my-project",
+		BuildTriggerId: "trigger-id",
+		Status:         cbpb.Build_SUCCESS,
+		Substitutions:  map[string]string{triggerNameSub: "my-trigger", repoSub: "my-repo"},
+	}
+	tmpl, err := parseBadgeNameTemplate("{{.Project}}/{{.Repo}}/{{.TriggerName}}.svg")
+	if err != nil {
+		t.Fatal("parseBadgeNameTemplate failed: ", err)
+	}
+
+	for _, tc := range []struct {
+		keepID bool
+		want   []string
+	}{
+		{false, []string{"my-project/my-repo/my-trigger.svg"}},
+		{true, []string{"my-project/my-repo/my-trigger.svg", "trigger-id.svg"}},
+	} {
+		ctx := context.Background()
+		st := newMemStore()
+		cfg := &Config{badgeBucket: "bucket", badgeStore: st,
+			badgeObjectTemplate: tmpl, badgeKeepIDName: tc.keepID}
+		if err := writeBadge(ctx, cfg, build); err != nil {
+			t.Fatal("writeBadge failed: ", err)
+		}
+		var got []string
+		for name := range st.objects {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("writeBadge with keep-ID %v wrote %q; want %q", tc.keepID, got, tc.want)
+		}
+	}
+}
+
+func TestCheckObjectName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ok   bool
+	}{
+		{"trigger.svg", true},
+		{"a/b/c.svg", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"foo\nbar.svg", false},
+		{"foo/", false},
+		{"\xff.svg", false},
+		{strings.Repeat("a", 1025), false},
+		{".well-known/acme-challenge/foo", false},
+	} {
+		if err := checkObjectName(tc.name); err != nil && tc.ok {
+			t.Errorf("checkObjectName(%q) failed: %v", tc.name, err)
+		} else if err == nil && !tc.ok {
+			t.Errorf("checkObjectName(%q) unexpectedly succeeded", tc.name)
+		}
+	}
+}
+
+func TestCreateBadge_Width(t *testing.T) {
+	for _, tc := range []struct {
+		status cbpb.Build_Status
+		want   int // "build" is 39 pixels wide
+	}{
+		{cbpb.Build_SUCCESS, 39 + 55},
+		{cbpb.Build_FAILURE, 39 + 47},
+		{cbpb.Build_INTERNAL_ERROR, 39 + 40},
+		{cbpb.Build_TIMEOUT, 39 + 55},
+	} {
+		var b bytes.Buffer
+		if err := CreateBadge(&b, nil, &cbpb.Build{Status: tc.status}); err != nil {
+			t.Fatalf("CreateBadge failed for %v: %v", tc.status, err)
+		}
+		var svg struct {
+			Width int `xml:"width,attr"`
+		}
+		if err := xml.Unmarshal(b.Bytes(), &svg); err != nil {
+			t.Fatalf("Badge for %v isn't valid XML: %v", tc.status, err)
+		}
+		if svg.Width != tc.want {
+			t.Errorf("Badge for %v has width %d; want %d", tc.status, svg.Width, tc.want)
+		}
+	}
+}
+
+func TestCreateBadge_Style(t *testing.T) {
+	for _, tc := range []struct {
+		style  string
+		height int
+		text   string // expected text of right section
+	}{
+		{"flat", 20, "success"},
+		{"flat-square", 20, "success"},
+		{"plastic", 18, "success"},
+		{"for-the-badge", 28, "SUCCESS"},
+	} {
+		cfg, err := FakeBadgeConfig("github", "build", tc.style)
+		if err != nil {
+			t.Fatalf("FakeBadgeConfig(%q) failed: %v", tc.style, err)
+		}
+		var b bytes.Buffer
+		if err := CreateBadge(&b, cfg, &cbpb.Build{Status: cbpb.Build_SUCCESS}); err != nil {
+			t.Fatalf("CreateBadge failed for %q: %v", tc.style, err)
+		}
+		var svg struct {
+			Height int `xml:"height,attr"`
+		}
+		if err := xml.Unmarshal(b.Bytes(), &svg); err != nil {
+			t.Fatalf("Badge for %q isn't valid XML: %v", tc.style, err)
+		}
+		if svg.Height != tc.height {
+			t.Errorf("Badge for %q has height %d; want %d", tc.style, svg.Height, tc.height)
+		}
+		if want := ">" + tc.text + "<"; !strings.Contains(b.String(), want) {
+			t.Errorf("Badge for %q doesn't contain %q", tc.style, tc.text)
+		}
+	}
+}
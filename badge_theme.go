@@ -0,0 +1,161 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// badgeTheme describes the text and colors used in badges.
+type badgeTheme struct {
+	label         badgeInfo                       // left side of badge
+	triggerLabels map[string]string               // label text keyed by trigger name or ID
+	statuses      map[cbpb.Build_Status]badgeInfo // right side of badge for each status
+}
+
+// defaultBadgeTheme is used when a Config doesn't supply a theme.
+var defaultBadgeTheme = &badgeTheme{label: badgeLeft, statuses: badgeStatuses}
+
+// info returns information for rendering the left and right sides of b's badge.
+// ok is false if b's status isn't displayed in badges.
+func (t *badgeTheme) info(b *cbpb.Build) (left, right badgeInfo, ok bool) {
+	left = t.label
+	if s, ok := t.triggerLabels[buildSub(b, triggerNameSub, "")]; ok {
+		left.Text = s
+	} else if s, ok := t.triggerLabels[b.BuildTriggerId]; ok {
+		left.Text = s
+	}
+	right, ok = t.statuses[b.Status]
+	return left, right, ok
+}
+
+// badgePalette contains colors for the sections of a badge. Text fields are unused.
+type badgePalette struct {
+	label    badgeInfo
+	statuses map[cbpb.Build_Status]badgeInfo
+}
+
+// badgePalettes contains palettes that can be selected using BADGE_PALETTE.
+var badgePalettes = map[string]badgePalette{
+	// Colors from GitHub's user interface. These are the defaults in badgeStatuses.
+	"github": {
+		label:    badgeLeft,
+		statuses: badgeStatuses,
+	},
+	// Colors used by shields.io.
+	"shields": {
+		label: badgeInfo{FG: "#fff", BG: "#555"},
+		statuses: map[cbpb.Build_Status]badgeInfo{
+			cbpb.Build_SUCCESS:        {FG: "#fff", BG: "#4c1"},
+			cbpb.Build_FAILURE:        {FG: "#fff", BG: "#e05d44"},
+			cbpb.Build_INTERNAL_ERROR: {FG: "#fff", BG: "#fe7d37"},
+			cbpb.Build_TIMEOUT:        {FG: "#fff", BG: "#9f9f9f"},
+			cbpb.Build_QUEUED:         {FG: "#fff", BG: "#007ec6"},
+			cbpb.Build_WORKING:        {FG: "#fff", BG: "#007ec6"},
+			cbpb.Build_CANCELLED:      {FG: "#fff", BG: "#9f9f9f"},
+			cbpb.Build_EXPIRED:        {FG: "#fff", BG: "#9f9f9f"},
+		},
+	},
+	// Dark backgrounds with white or yellow text for readability.
+	"high-contrast": {
+		label: badgeInfo{FG: "#fff", BG: "#000"},
+		statuses: map[cbpb.Build_Status]badgeInfo{
+			cbpb.Build_SUCCESS:        {FG: "#fff", BG: "#005a00"},
+			cbpb.Build_FAILURE:        {FG: "#fff", BG: "#a00000"},
+			cbpb.Build_INTERNAL_ERROR: {FG: "#ff0", BG: "#000"},
+			cbpb.Build_TIMEOUT:        {FG: "#fff", BG: "#303030"},
+			cbpb.Build_QUEUED:         {FG: "#fff", BG: "#00318a"},
+			cbpb.Build_WORKING:        {FG: "#fff", BG: "#00318a"},
+			cbpb.Build_CANCELLED:      {FG: "#fff", BG: "#303030"},
+			cbpb.Build_EXPIRED:        {FG: "#fff", BG: "#303030"},
+		},
+	},
+}
+
+// colorRegexp matches colors accepted in badge themes.
+var colorRegexp = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// newBadgeTheme returns a badgeTheme using the named palette from badgePalettes.
+// label is the default left-side text and labelColors is an optional "BG" or "BG/FG" color
+// override for the left side. triggerLabels contains left-side text keyed by trigger name or ID.
+// statusText and statusColors contain overrides for the right side keyed by status names.
+func newBadgeTheme(palette, label, labelColors string, triggerLabels,
+	statusText, statusColors map[string]string) (*badgeTheme, error) {
+	pal, ok := badgePalettes[palette]
+	if !ok {
+		return nil, fmt.Errorf("unknown palette %q", palette)
+	}
+
+	t := &badgeTheme{
+		label:         badgeInfo{Text: label, FG: pal.label.FG, BG: pal.label.BG},
+		triggerLabels: triggerLabels,
+		statuses:      make(map[cbpb.Build_Status]badgeInfo, len(badgeStatuses)),
+	}
+	for st, def := range badgeStatuses {
+		info := def
+		if c, ok := pal.statuses[st]; ok {
+			info.FG, info.BG = c.FG, c.BG
+		}
+		t.statuses[st] = info
+	}
+
+	if labelColors != "" {
+		if err := parseBadgeColors(labelColors, &t.label); err != nil {
+			return nil, err
+		}
+	}
+	for name, text := range statusText {
+		st, err := badgeStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		info := t.statuses[st]
+		info.Text = text
+		t.statuses[st] = info
+	}
+	for name, colors := range statusColors {
+		st, err := badgeStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		info := t.statuses[st]
+		if err := parseBadgeColors(colors, &info); err != nil {
+			return nil, err
+		}
+		t.statuses[st] = info
+	}
+	return t, nil
+}
+
+// badgeStatus returns the status named by s. An error is returned if s
+// doesn't name a status that is displayed in badges.
+func badgeStatus(s string) (cbpb.Build_Status, error) {
+	st := cbpb.Build_Status(cbpb.Build_Status_value[s])
+	if _, ok := badgeStatuses[st]; !ok {
+		return 0, fmt.Errorf("bad badge status %q", s)
+	}
+	return st, nil
+}
+
+// parseBadgeColors parses s, a "BG" or "BG/FG" color pair, into info.
+func parseBadgeColors(s string, info *badgeInfo) error {
+	colors := strings.Split(s, "/")
+	if len(colors) > 2 {
+		return fmt.Errorf("bad colors %q", s)
+	}
+	for _, c := range colors {
+		if !colorRegexp.MatchString(c) {
+			return fmt.Errorf("bad color %q", c)
+		}
+	}
+	info.BG = colors[0]
+	if len(colors) == 2 {
+		info.FG = colors[1]
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestNewBadgeTheme(t *testing.T) {
+	theme, err := newBadgeTheme("shields", "tests", "#123/#eee",
+		map[string]string{"deploy-trigger": "deploy", "trigger-id": "by-id"},
+		map[string]string{"SUCCESS": "passing"},
+		map[string]string{"FAILURE": "#f00", "TIMEOUT": "#abcdef/#000"})
+	if err != nil {
+		t.Fatal("newBadgeTheme failed: ", err)
+	}
+
+	for _, tc := range []struct {
+		build       *cbpb.Build
+		left, right badgeInfo // Width is ignored
+	}{
+		{makeBuild("", "other-id", cbpb.Build_SUCCESS, "", triggerNameSub, "other"),
+			badgeInfo{Text: "tests", FG: "#eee", BG: "#123"},
+			badgeInfo{Text: "passing", FG: "#fff", BG: "#4c1"}},
+		{makeBuild("", "other-id", cbpb.Build_FAILURE, "", triggerNameSub, "deploy-trigger"),
+			badgeInfo{Text: "deploy", FG: "#eee", BG: "#123"},
+			badgeInfo{Text: "failure", FG: "#fff", BG: "#f00"}},
+		{makeBuild("", "trigger-id", cbpb.Build_TIMEOUT, "", triggerNameSub, "other"),
+			badgeInfo{Text: "by-id", FG: "#eee", BG: "#123"},
+			badgeInfo{Text: "timeout", FG: "#000", BG: "#abcdef"}},
+	} {
+		left, right, ok := theme.info(tc.build)
+		if !ok {
+			t.Errorf("info(%v) failed", tc.build.Status)
+			continue
+		}
+		if left != tc.left || right != tc.right {
+			t.Errorf("info(%v) = %+v, %+v; want %+v, %+v",
+				tc.build.Status, left, right, tc.left, tc.right)
+		}
+	}
+	if _, _, ok := theme.info(makeBuild("", "id", cbpb.Build_STATUS_UNKNOWN, "", triggerNameSub, "name")); ok {
+		t.Error("info unexpectedly succeeded for STATUS_UNKNOWN")
+	}
+}
+
+func TestNewBadgeTheme_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		palette, labelColors     string
+		statusText, statusColors map[string]string
+		desc                     string
+	}{
+		{"bogus", "", nil, nil, "bad palette"},
+		{"github", "red", nil, nil, "bad label color"},
+		{"github", "#fff/#000/#123", nil, nil, "too many label colors"},
+		{"github", "", map[string]string{"BOGUS": "foo"}, nil, "bad text status"},
+		{"github", "", map[string]string{"STATUS_UNKNOWN": "foo"}, nil, "non-badge text status"},
+		{"github", "", nil, map[string]string{"SUCCESS": "#12345"}, "bad status color"},
+		{"github", "", nil, map[string]string{"SUCCESS": "#fff/green"}, "bad status text color"},
+	} {
+		if _, err := newBadgeTheme(tc.palette, "build", tc.labelColors, nil,
+			tc.statusText, tc.statusColors); err == nil {
+			t.Errorf("%s: newBadgeTheme unexpectedly succeeded", tc.desc)
+		}
+	}
+}
+
+func TestCreateBadge_Theme(t *testing.T) {
+	cfg, err := FakeBadgeConfig("high-contrast", "integration tests", "flat")
+	if err != nil {
+		t.Fatal("FakeBadgeConfig failed: ", err)
+	}
+	var b bytes.Buffer
+	if err := CreateBadge(&b, cfg, &cbpb.Build{Status: cbpb.Build_SUCCESS}); err != nil {
+		t.Fatal("CreateBadge failed: ", err)
+	}
+	for _, want := range []string{"integration tests", "#005a00"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("%q doesn't appear in badge:\n%v", want, b.String())
+		}
+	}
+}
+
+func TestCreateBadge_EscapeText(t *testing.T) {
+	cfg, err := FakeBadgeConfig("github", "R&D <x>", "flat")
+	if err != nil {
+		t.Fatal("FakeBadgeConfig failed: ", err)
+	}
+	var b bytes.Buffer
+	if err := CreateBadge(&b, cfg, &cbpb.Build{Status: cbpb.Build_SUCCESS}); err != nil {
+		t.Fatal("CreateBadge failed: ", err)
+	}
+	var svg struct {
+		Texts []string `xml:"g>text"`
+	}
+	if err := xml.Unmarshal(b.Bytes(), &svg); err != nil {
+		t.Fatalf("Badge isn't valid XML: %v\n%v", err, b.String())
+	}
+	if len(svg.Texts) == 0 || svg.Texts[0] != "R&D <x>" {
+		t.Errorf("Badge has text %q; want label %q first", svg.Texts, "R&D <x>")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	ttemplate "text/template"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// durationInfo describes how the right side of duration badges should be rendered.
+var durationInfo = badgeInfo{FG: "#fff", BG: "#007ec6"}
+
+// createDurationBadge writes an SVG badge image displaying build's duration per cfg to w.
+func createDurationBadge(w io.Writer, cfg *Config, build *cbpb.Build) error {
+	theme, style := badgeAppearance(cfg)
+	left, right := theme.label, durationInfo
+	left.Text = "duration"
+	right.Text = formatDuration(build.FinishTime.AsTime().Sub(build.StartTime.AsTime()))
+	for _, info := range []*badgeInfo{&left, &right} {
+		info.Text = style.text(info.Text)
+		info.Width = style.sectionWidth(info.Text)
+	}
+	return renderBadge(w, left, right, style, false,
+		build.StartTime.AsTime().UTC().Format(badgeTimeLayout))
+}
+
+const (
+	trendBarWidth  = 4 // width of each bar in trend images in pixels
+	trendBarGap    = 1 // horizontal space between bars in pixels
+	trendMinHeight = 2 // minimum bar height in pixels
+)
+
+// trendBar describes a single bar in a trend image.
+type trendBar struct {
+	X, Y, Height int
+	Color        string
+	Title        string // tooltip text
+}
+
+// createTrend writes an SVG sparkline to w displaying the durations and statuses of the builds
+// in entries, ordered from oldest to newest. Colors come from cfg's badge theme.
+func createTrend(w io.Writer, cfg *Config, entries []historyEntry) error {
+	theme, style := badgeAppearance(cfg)
+
+	var max time.Duration
+	for _, e := range entries {
+		if d := e.duration(); d > max {
+			max = d
+		}
+	}
+	bars := make([]trendBar, len(entries))
+	for i, e := range entries {
+		h := style.Height
+		if max > 0 {
+			h = int(float64(style.Height) * float64(e.duration()) / float64(max))
+		}
+		if h < trendMinHeight {
+			h = trendMinHeight
+		}
+		color := theme.label.BG
+		if info, ok := theme.statuses[cbpb.Build_Status(cbpb.Build_Status_value[e.Status])]; ok {
+			color = info.BG
+		}
+		bars[i] = trendBar{
+			X:      i * (trendBarWidth + trendBarGap),
+			Y:      style.Height - h,
+			Height: h,
+			Color:  color,
+			Title: fmt.Sprintf("%s %s %s", e.CreateTime.Format(badgeTimeLayout),
+				strings.ToLower(e.Status), formatDuration(e.duration())),
+		}
+	}
+
+	tmpl, err := ttemplate.New("").Parse(strings.TrimSpace(trendTemplate))
+	if err != nil {
+		return err
+	}
+	width := len(bars)*(trendBarWidth+trendBarGap) - trendBarGap
+	if width < 0 {
+		width = 0
+	}
+	return tmpl.Execute(w, struct {
+		Width, Height int
+		BarWidth      int
+		Bars          []trendBar
+	}{width, style.Height, trendBarWidth, bars})
+}
+
+const trendTemplate = `
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+  {{- range .Bars}}
+  <rect x="{{.X}}" y="{{.Y}}" width="{{$.BarWidth}}" height="{{.Height}}" fill="{{.Color}}">
+    <title>{{.Title}}</title>
+  </rect>
+  {{- end}}
+</svg>
+`
@@ -4,14 +4,19 @@
 package watch
 
 import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/mail"
 	"os"
-	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
@@ -24,24 +29,88 @@ type Config struct {
 	emailPort     int    // server port, e.g. 587
 	emailUsername string // server username, e.g. "apikey"
 	emailPassword string // server password, e.g. "my-secret-api-key"
+	emailAuth     string // auth mechanism, e.g. "plain", "login", "cram-md5", or "xoauth2"
+
+	emailOAuthClientID     string   // OAuth 2.0 client ID for XOAUTH2
+	emailOAuthClientSecret string   // OAuth 2.0 client secret for XOAUTH2
+	emailOAuthRefreshToken string   // OAuth 2.0 refresh token for XOAUTH2
+	emailOAuthKey          []byte   // JSON service account key for XOAUTH2 via domain-wide delegation
+	emailOAuthTokenURL     string   // OAuth 2.0 token endpoint, empty for default
+	emailOAuthScopes       []string // OAuth 2.0 scopes to request, empty for default
+
+	emailTLSMode       string         // e.g. "starttls", "required", "implicit", or "none"
+	emailTLSRootCAs    *x509.CertPool // CAs for verifying server certificates, nil for system pool
+	emailTLSServerName string         // name for verifying server certificate, empty for emailHostname
 
 	emailFrom       *mail.Address   // from address
 	emailRecipients []*mail.Address // recipients
 	emailTimeZone   *time.Location  // used for dates
+	emailRetries    int             // retries after temporary SMTP errors
+
+	emailFilter buildFilter // builds to send email about
+
+	slackWebhookURL string      // Slack incoming webhook URL, e.g. "https://hooks.slack.com/services/..."
+	slackFilter     buildFilter // builds to post to Slack
+
+	webhookURL     string            // URL to POST JSON build summaries to
+	webhookSecret  string            // key for HMAC-SHA256 X-Signature header, empty to not sign
+	webhookHeaders map[string]string // additional request headers
+	webhookTimeout time.Duration     // timeout for each request
+	webhookRetries int               // retries after failed requests
+	webhookFilter  buildFilter       // builds to POST
+
+	githubAPIURL       string          // GitHub REST API base URL, e.g. "https://api.github.com"
+	githubToken        string          // personal access token, empty to use GitHub App
+	githubAppID        int64           // GitHub App ID
+	githubAppInstallID int64           // GitHub App installation ID
+	githubAppKey       *rsa.PrivateKey // GitHub App private key
+	githubOwner        string          // repo owner if REPO_FULL_NAME is unset, e.g. "my-org"
+	githubContext      string          // commit status context, e.g. "cloud-build"
+	githubFilter       buildFilter     // builds to report to GitHub
+
+	routes []*route // additional routes from CONFIG_FILE
+	route  string   // name of route that this Config was derived from, empty for default
+
+	state          objectStore // stores previous builds' statuses, nil if disabled
+	statePerBranch bool        // track state separately for each branch
+	redeliver      bool        // return errors so Pub/Sub redelivers messages
+	dedupe         bool        // run each notifier at most once per build ID and status
 
-	emailBuildTriggerIDs   map[string]struct{} // Cloud Build trigger IDs, empty to not check
-	emailBuildTriggerNames map[string]struct{} // Cloud Build trigger names, empty to not check
-	emailBuildStatuses     map[string]struct{} // Cloud Build statuses, e.g. "SUCCESS" or "FAILURE"
+	badgeBucket   string      // Cloud Storage bucket into which badges should be written, e.g. "my-bucket"
+	badgeStore    objectStore // writes objects to badgeBucket
+	badgeReports  bool        // write brief HTML reports alongside badges
+	badgeEndpoint bool        // write shields.io endpoint JSON alongside badges
+	badgePNG      bool        // write PNG images alongside SVG badges
+	badgeRunning  bool        // update badges while builds are queued or running
+	badgeDuration bool        // write badges displaying build durations
+	badgeIndex    bool        // write index.html listing all triggers' badges
+	badgeFilter   buildFilter // builds to write badges for
+	badgeTheme    *badgeTheme // badge text and colors
+	badgeStyle    *badgeStyle // badge shape and typography
 
-	badgeBucket  string // Cloud Storage bucket into which badges should be written, e.g. "my-bucket"
-	badgeReports bool   // write brief HTML reports alongside badges
+	badgeHistory     objectStore // stores recent builds for each badge, nil if disabled
+	badgeHistorySize int         // maximum number of builds in each history object
+	badgeTrend       bool        // write sparklines of recent builds' durations
+	badgeReportHist  bool        // list recent builds in HTML reports
+
+	// Generate names of badge objects from badgeNameData.
+	badgeObjectTemplate *template.Template // nil to use "<trigger-id>.svg"
+	badgeBranchTemplate *template.Template // per-branch badges, nil if disabled
+	badgeKeepIDName     bool               // also write "<trigger-id>.svg" if badgeObjectTemplate is set
 }
 
 var listRegexp = regexp.MustCompile(`\s*,\s*`)
 
-// loadConfig constructs a new Config object from environment variables.
+// defaultFilterStatuses is the default value for *_BUILD_STATUSES variables.
+const defaultFilterStatuses = "FAILURE,INTERNAL_ERROR,TIMEOUT"
+
+// defaultGitHubStatuses is the default value for GITHUB_BUILD_STATUSES.
+const defaultGitHubStatuses = "QUEUED,WORKING,SUCCESS,FAILURE,INTERNAL_ERROR,TIMEOUT,CANCELLED,EXPIRED"
+
+// loadConfig constructs a new Config object from environment variables
+// and the file named by CONFIG_FILE, if any.
 // An error is returned if any variables are unparseable.
-func loadConfig() (*Config, error) {
+func loadConfig(ctx context.Context) (*Config, error) {
 	var firstErr error
 	saveError := func(err error) {
 		if err != nil && firstErr == nil {
@@ -56,6 +125,23 @@ func loadConfig() (*Config, error) {
 		}
 		return def
 	}
+	// secretVar returns the value of n. If n_SECRET is set instead, it is treated as a
+	// reference to a secret (e.g. "projects/p/secrets/s/versions/latest") to resolve.
+	secretVar := func(n string) string {
+		ref := strVar(n+"_SECRET", "")
+		if ref == "" {
+			return strVar(n, "")
+		}
+		if strVar(n, "") != "" {
+			saveError(fmt.Errorf("%v and %v_SECRET both set", n, n))
+			return ""
+		}
+		v, err := configSecrets.secret(ctx, ref)
+		if err != nil {
+			saveError(fmt.Errorf("bad %v_SECRET: %v", n, err))
+		}
+		return v
+	}
 	intVar := func(n, def string) int {
 		v, err := strconv.Atoi(strVar(n, def))
 		saveError(err)
@@ -87,18 +173,118 @@ func loadConfig() (*Config, error) {
 		}
 		return v
 	}
+	durationVar := func(n, def string) time.Duration {
+		v, err := time.ParseDuration(strVar(n, def))
+		saveError(err)
+		return v
+	}
+	mapVar := func(n, def string) map[string]string {
+		ev := strVar(n, def)
+		if len(ev) == 0 {
+			return nil
+		}
+		v := make(map[string]string)
+		for _, s := range listRegexp.Split(ev, -1) {
+			parts := strings.SplitN(s, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				saveError(fmt.Errorf("bad NAME=value pair %q in %v", s, n))
+				continue
+			}
+			v[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		return v
+	}
+	subsVar := func(n string) map[string]map[string]struct{} {
+		ev := strVar(n, "")
+		if len(ev) == 0 {
+			return nil
+		}
+		v := make(map[string]map[string]struct{})
+		for _, s := range listRegexp.Split(ev, -1) {
+			parts := strings.SplitN(s, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				saveError(fmt.Errorf("bad NAME=glob pair %q in %v", s, n))
+				continue
+			}
+			name := strings.TrimSpace(parts[0])
+			if v[name] == nil {
+				v[name] = make(map[string]struct{})
+			}
+			v[name][strings.TrimSpace(parts[1])] = struct{}{}
+		}
+		return v
+	}
+	filterVar := func(prefix, defStatuses string) buildFilter {
+		f := buildFilter{
+			prefix:               prefix,
+			triggerIDs:           listVar(prefix+"TRIGGER_IDS", ""),
+			triggerNames:         listVar(prefix+"TRIGGER_NAMES", ""),
+			excludeTriggerIDs:    listVar(prefix+"EXCLUDE_TRIGGER_IDS", ""),
+			excludeTriggerNames:  listVar(prefix+"EXCLUDE_TRIGGER_NAMES", ""),
+			events:               listVar(prefix+"EVENTS", ""),
+			branches:             listVar(prefix+"BRANCHES", ""),
+			repos:                listVar(prefix+"REPOS", ""),
+			tags:                 listVar(prefix+"TAGS", ""),
+			excludeBranches:      listVar(prefix+"EXCLUDE_BRANCHES", ""),
+			excludeRepos:         listVar(prefix+"EXCLUDE_REPOS", ""),
+			excludeTags:          listVar(prefix+"EXCLUDE_TAGS", ""),
+			substitutions:        subsVar(prefix + "SUBSTITUTIONS"),
+			excludeSubstitutions: subsVar(prefix + "EXCLUDE_SUBSTITUTIONS"),
+		}
+		f.splitNegated()
+		// Don't apply the default statuses when filtering by event,
+		// since they'd probably exclude FIXED builds.
+		if len(f.events) > 0 {
+			defStatuses = ""
+		}
+		f.statuses = listVar(prefix+"STATUSES", defStatuses)
+		return f
+	}
 
 	// Parse simple fields.
 	cfg := Config{
-		emailHostname:          strVar("EMAIL_HOSTNAME", ""),
-		emailPort:              intVar("EMAIL_PORT", "25"),
-		emailUsername:          strVar("EMAIL_USERNAME", ""),
-		emailPassword:          strVar("EMAIL_PASSWORD", ""),
-		emailBuildTriggerIDs:   listVar("EMAIL_BUILD_TRIGGER_IDS", ""),
-		emailBuildTriggerNames: listVar("EMAIL_BUILD_TRIGGER_NAMES", ""),
-		emailBuildStatuses:     listVar("EMAIL_BUILD_STATUSES", "FAILURE,INTERNAL_ERROR,TIMEOUT"),
-		badgeBucket:            strVar("BADGE_BUCKET", ""),
-		badgeReports:           boolVar("BADGE_REPORTS", "false"),
+		emailHostname: strVar("EMAIL_HOSTNAME", ""),
+		emailPort:     intVar("EMAIL_PORT", "25"),
+		emailUsername: strVar("EMAIL_USERNAME", ""),
+		emailPassword: secretVar("EMAIL_PASSWORD"),
+		emailAuth:     strings.ToLower(strVar("EMAIL_AUTH", authPlain)),
+		emailTLSMode:  strings.ToLower(strVar("EMAIL_TLS_MODE", tlsModeStartTLS)),
+
+		emailOAuthClientID:     strVar("EMAIL_OAUTH_CLIENT_ID", ""),
+		emailOAuthClientSecret: secretVar("EMAIL_OAUTH_CLIENT_SECRET"),
+		emailOAuthRefreshToken: secretVar("EMAIL_OAUTH_REFRESH_TOKEN"),
+		emailOAuthKey:          []byte(secretVar("EMAIL_OAUTH_SERVICE_ACCOUNT_KEY")),
+		emailOAuthTokenURL:     strVar("EMAIL_OAUTH_TOKEN_URL", ""),
+
+		emailTLSServerName: strVar("EMAIL_TLS_SERVER_NAME", ""),
+		emailRetries:       intVar("EMAIL_RETRIES", "2"),
+		emailFilter:        filterVar("EMAIL_BUILD_", defaultFilterStatuses),
+		slackWebhookURL:    secretVar("SLACK_WEBHOOK_URL"),
+		slackFilter:        filterVar("SLACK_BUILD_", defaultFilterStatuses),
+		webhookURL:         strVar("WEBHOOK_URL", ""),
+		webhookSecret:      secretVar("WEBHOOK_SECRET"),
+		webhookHeaders:     mapVar("WEBHOOK_HEADERS", ""),
+		webhookTimeout:     durationVar("WEBHOOK_TIMEOUT", "10s"),
+		webhookRetries:     intVar("WEBHOOK_RETRIES", "2"),
+		webhookFilter:      filterVar("WEBHOOK_BUILD_", defaultFilterStatuses),
+		githubAPIURL:       strings.TrimSuffix(strVar("GITHUB_API_URL", "https://api.github.com"), "/"),
+		githubToken:        secretVar("GITHUB_TOKEN"),
+		githubAppID:        int64(intVar("GITHUB_APP_ID", "0")),
+		githubAppInstallID: int64(intVar("GITHUB_APP_INSTALLATION_ID", "0")),
+		githubOwner:        strVar("GITHUB_OWNER", ""),
+		githubContext:      strVar("GITHUB_STATUS_CONTEXT", "cloud-build"),
+		githubFilter:       filterVar("GITHUB_BUILD_", defaultGitHubStatuses),
+		badgeBucket:        strVar("BADGE_BUCKET", ""),
+		badgeReports:       boolVar("BADGE_REPORTS", "false"),
+		badgeReportHist:    boolVar("BADGE_REPORT_HISTORY", "false"),
+		badgeEndpoint:      boolVar("BADGE_ENDPOINT_JSON", "false"),
+		badgePNG:           boolVar("BADGE_PNG", "false"),
+		badgeRunning:       boolVar("BADGE_IN_PROGRESS", "false"),
+		badgeDuration:      boolVar("BADGE_DURATION", "false"),
+		badgeIndex:         boolVar("BADGE_INDEX", "false"),
+		badgeHistorySize:   intVar("BADGE_HISTORY_SIZE", strconv.Itoa(defaultHistorySize)),
+		badgeTrend:         boolVar("BADGE_TREND", "false"),
+		badgeFilter:        filterVar("BADGE_BUILD_", ""),
 	}
 	if firstErr != nil {
 		return nil, firstErr
@@ -117,25 +303,205 @@ func loadConfig() (*Config, error) {
 		}
 	}
 
+	// Validate SMTP settings.
+	switch cfg.emailAuth {
+	case authPlain, authLogin, authCRAMMD5:
+	case authXOAUTH2:
+		if cfg.emailUsername == "" {
+			return nil, errors.New("EMAIL_AUTH xoauth2 requires EMAIL_USERNAME")
+		}
+		if len(cfg.emailOAuthKey) == 0 &&
+			(cfg.emailOAuthClientID == "" || cfg.emailOAuthRefreshToken == "") {
+			return nil, errors.New("EMAIL_AUTH xoauth2 requires EMAIL_OAUTH_SERVICE_ACCOUNT_KEY " +
+				"or EMAIL_OAUTH_CLIENT_ID and EMAIL_OAUTH_REFRESH_TOKEN")
+		}
+		for s := range listVar("EMAIL_OAUTH_SCOPES", "") {
+			cfg.emailOAuthScopes = append(cfg.emailOAuthScopes, s)
+		}
+		sort.Strings(cfg.emailOAuthScopes)
+	default:
+		return nil, fmt.Errorf("bad EMAIL_AUTH %q", cfg.emailAuth)
+	}
+	switch cfg.emailTLSMode {
+	case tlsModeStartTLS, tlsModeRequired, tlsModeImplicit, tlsModeNone:
+	default:
+		return nil, fmt.Errorf("bad EMAIL_TLS_MODE %q", cfg.emailTLSMode)
+	}
+	if p := strVar("EMAIL_TLS_CA_FILE", ""); p != "" {
+		pem, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad EMAIL_TLS_CA_FILE: %v", err)
+		}
+		cfg.emailTLSRootCAs = x509.NewCertPool()
+		if !cfg.emailTLSRootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in EMAIL_TLS_CA_FILE %v", p)
+		}
+	}
+
 	// Load and validate time zone.
 	if cfg.emailTimeZone, err = time.LoadLocation(strVar("EMAIL_TIME_ZONE", "Etc/UTC")); err != nil {
 		return nil, fmt.Errorf("bad EMAIL_TIME_ZONE: %v", err)
 	}
 
-	// Validate build statuses.
-	for s := range cfg.emailBuildStatuses {
-		if _, ok := cbpb.Build_Status_value[s]; !ok {
-			return nil, fmt.Errorf("bad status %q in EMAIL_BUILD_STATUSES", s)
+	// Validate build filters.
+	for _, f := range []*buildFilter{
+		&cfg.emailFilter, &cfg.slackFilter, &cfg.webhookFilter, &cfg.githubFilter, &cfg.badgeFilter,
+	} {
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse GitHub App key.
+	if v := secretVar("GITHUB_APP_KEY"); v != "" {
+		if cfg.githubAppKey, err = parseRSAKey([]byte(v)); err != nil {
+			return nil, fmt.Errorf("bad GITHUB_APP_KEY: %v", err)
+		}
+	}
+
+	// Load routes from the config file.
+	if p := strVar("CONFIG_FILE", ""); p != "" {
+		data, err := readConfigFile(ctx, p)
+		if err != nil {
+			return nil, fmt.Errorf("bad CONFIG_FILE: %v", err)
+		}
+		if cfg.routes, err = parseRoutes(data); err != nil {
+			return nil, fmt.Errorf("bad CONFIG_FILE %v: %v", p, err)
+		}
+		for _, r := range cfg.routes {
+			if r.webhookSecretRef == "" {
+				continue
+			}
+			if r.webhookSecret, err = configSecrets.secret(ctx, r.webhookSecretRef); err != nil {
+				return nil, fmt.Errorf("bad webhook secret for route %q: %v", r.name, err)
+			}
 		}
 	}
 
+	// Set up state tracking.
+	if v := strVar("STATE_BUCKET", ""); v != "" {
+		cfg.state = &gcsStore{bucket: v, prefix: strVar("STATE_PREFIX", "state/")}
+	}
+	cfg.statePerBranch = boolVar("STATE_PER_BRANCH", "false")
+	cfg.redeliver = boolVar("REDELIVER_ON_ERROR", "false")
+	// Redelivered messages would otherwise repeat notifications that already succeeded.
+	cfg.dedupe = boolVar("DEDUPE_NOTIFICATIONS", "false") || cfg.redeliver
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if cfg.redeliver && cfg.state == nil {
+		return nil, errors.New("REDELIVER_ON_ERROR requires STATE_BUCKET")
+	}
+	if cfg.dedupe && cfg.state == nil {
+		return nil, errors.New("DEDUPE_NOTIFICATIONS requires STATE_BUCKET")
+	}
+
+	// Events are computed using previous builds' statuses, so they require state tracking.
+	if cfg.state == nil {
+		filters := []*buildFilter{
+			&cfg.emailFilter, &cfg.slackFilter, &cfg.webhookFilter, &cfg.githubFilter, &cfg.badgeFilter,
+		}
+		for _, r := range cfg.routes {
+			filters = append(filters, &r.filter)
+		}
+		for _, f := range filters {
+			if len(f.events) > 0 {
+				return nil, fmt.Errorf("%sEVENTS requires STATE_BUCKET", f.prefix)
+			}
+		}
+	}
+
+	if cfg.badgeBucket != "" {
+		cfg.badgeStore = &gcsStore{bucket: cfg.badgeBucket}
+	}
+	if cfg.badgeTheme, err = newBadgeTheme(
+		strVar("BADGE_PALETTE", "github"),
+		strVar("BADGE_LABEL", badgeLeft.Text),
+		strVar("BADGE_LABEL_COLORS", ""),
+		mapVar("BADGE_TRIGGER_LABELS", ""),
+		mapVar("BADGE_STATUS_TEXT", ""),
+		mapVar("BADGE_STATUS_COLORS", ""),
+	); err != nil {
+		return nil, fmt.Errorf("bad badge appearance: %v", err)
+	}
+	if v := strVar("BADGE_STYLE", defaultBadgeStyle); badgeStyles[v] != nil {
+		cfg.badgeStyle = badgeStyles[v]
+	} else {
+		return nil, fmt.Errorf("bad BADGE_STYLE %q", v)
+	}
+	if v := strVar("BADGE_OBJECT_TEMPLATE", ""); v != "" {
+		if cfg.badgeObjectTemplate, err = parseBadgeNameTemplate(v); err != nil {
+			return nil, fmt.Errorf("bad BADGE_OBJECT_TEMPLATE: %v", err)
+		}
+	}
+	if v := strVar("BADGE_BRANCH_TEMPLATE", ""); v != "" {
+		if cfg.badgeBranchTemplate, err = parseBadgeNameTemplate(v); err != nil {
+			return nil, fmt.Errorf("bad BADGE_BRANCH_TEMPLATE: %v", err)
+		}
+	}
+	if cfg.badgeKeepIDName = boolVar("BADGE_KEEP_ID_NAME", "false"); firstErr != nil {
+		return nil, firstErr
+	}
 	if cfg.badgeReports && cfg.badgeBucket == "" {
 		return nil, errors.New("BADGE_REPORTS requires BADGE_BUCKET")
 	}
+	if cfg.badgeReportHist && !cfg.badgeReports {
+		return nil, errors.New("BADGE_REPORT_HISTORY requires BADGE_REPORTS")
+	}
+	if cfg.badgeEndpoint && cfg.badgeBucket == "" {
+		return nil, errors.New("BADGE_ENDPOINT_JSON requires BADGE_BUCKET")
+	}
+	if cfg.badgePNG && cfg.badgeBucket == "" {
+		return nil, errors.New("BADGE_PNG requires BADGE_BUCKET")
+	}
+	if cfg.badgeDuration && cfg.badgeBucket == "" {
+		return nil, errors.New("BADGE_DURATION requires BADGE_BUCKET")
+	}
+	if cfg.badgeTrend && cfg.badgeBucket == "" {
+		return nil, errors.New("BADGE_TREND requires BADGE_BUCKET")
+	}
+	if cfg.badgeIndex && cfg.badgeBucket == "" {
+		return nil, errors.New("BADGE_INDEX requires BADGE_BUCKET")
+	}
+	if cfg.badgeHistorySize <= 0 {
+		return nil, fmt.Errorf("bad BADGE_HISTORY_SIZE %d", cfg.badgeHistorySize)
+	}
+
+	// History can be stored in a local directory or a "gs://bucket/prefix" Cloud Storage location.
+	// It's stored in the badge bucket by default if it's needed.
+	if v := strVar("BADGE_HISTORY", ""); strings.HasPrefix(v, "gs://") {
+		parts := strings.SplitN(strings.TrimPrefix(v, "gs://"), "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("bad BADGE_HISTORY %q", v)
+		}
+		st := &gcsStore{bucket: parts[0]}
+		if len(parts) == 2 && parts[1] != "" {
+			st.prefix = strings.TrimSuffix(parts[1], "/") + "/"
+		}
+		cfg.badgeHistory = st
+	} else if v != "" {
+		cfg.badgeHistory = &dirStore{dir: v}
+	} else if cfg.badgeTrend || cfg.badgeReportHist {
+		cfg.badgeHistory = &gcsStore{bucket: cfg.badgeBucket, prefix: "history/"}
+	}
 
 	return &cfg, nil
 }
 
+// FakeBadgeConfig returns a minimal Config for use by the test_badge program.
+// palette, label, and style correspond to BADGE_PALETTE, BADGE_LABEL, and BADGE_STYLE.
+func FakeBadgeConfig(palette, label, style string) (*Config, error) {
+	theme, err := newBadgeTheme(palette, label, "", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	bs, ok := badgeStyles[style]
+	if !ok {
+		return nil, fmt.Errorf("unknown style %q", style)
+	}
+	return &Config{badgeTheme: theme, badgeStyle: bs}, nil
+}
+
 // FakeConfig returns a minimal Config for use by the test_email program.
 func FakeConfig(from, to *mail.Address) *Config {
 	return &Config{
@@ -147,7 +513,7 @@ func FakeConfig(from, to *mail.Address) *Config {
 
 // checkEmail returns nil if an email notification should be sent for b
 // per cfg and a descriptive error otherwise.
-func (cfg *Config) checkEmail(b *cbpb.Build) error {
+func (cfg *Config) checkEmail(b *cbpb.Build, ev Event) error {
 	if cfg.emailHostname == "" {
 		return errors.New("EMAIL_HOSTNAME not set")
 	}
@@ -160,34 +526,49 @@ func (cfg *Config) checkEmail(b *cbpb.Build) error {
 	if len(cfg.emailRecipients) == 0 {
 		return errors.New("EMAIL_RECIPIENTS not set")
 	}
-	if len(cfg.emailBuildTriggerIDs) > 0 || len(cfg.emailBuildTriggerNames) > 0 {
-		name := buildSub(b, triggerNameSub, "")
-		_, idOk := cfg.emailBuildTriggerIDs[b.BuildTriggerId]
-		_, nameOk := cfg.emailBuildTriggerNames[name]
+	return cfg.emailFilter.check(b, ev)
+}
 
-		checkGlobs := func() bool {
-			for p := range cfg.emailBuildTriggerNames {
-				if m, err := filepath.Match(p, name); err == nil && m {
-					return true
-				}
-			}
-			return false
-		}
+// checkSlack returns nil if a Slack message should be posted for b
+// per cfg and a descriptive error otherwise.
+func (cfg *Config) checkSlack(b *cbpb.Build, ev Event) error {
+	if cfg.slackWebhookURL == "" {
+		return errors.New("SLACK_WEBHOOK_URL not set")
+	}
+	return cfg.slackFilter.check(b, ev)
+}
 
-		if !idOk && !nameOk && !checkGlobs() {
-			return fmt.Errorf("trigger %v (%q) not matched by EMAIL_BUILD_TRIGGER_IDS or "+
-				"EMAIL_BUILD_TRIGGER_NAMES", b.BuildTriggerId, name)
-		}
+// checkWebhook returns nil if a JSON summary of b should be POSTed
+// per cfg and a descriptive error otherwise.
+func (cfg *Config) checkWebhook(b *cbpb.Build, ev Event) error {
+	if cfg.webhookURL == "" {
+		return errors.New("WEBHOOK_URL not set")
 	}
-	if _, ok := cfg.emailBuildStatuses[b.Status.String()]; !ok {
-		return fmt.Errorf("status %q not matched by EMAIL_BUILD_STATUSES", b.Status)
+	return cfg.webhookFilter.check(b, ev)
+}
+
+// checkGitHub returns nil if a GitHub commit status should be set for b
+// per cfg and a descriptive error otherwise.
+func (cfg *Config) checkGitHub(b *cbpb.Build, ev Event) error {
+	if cfg.githubToken == "" &&
+		(cfg.githubAppID == 0 || cfg.githubAppInstallID == 0 || cfg.githubAppKey == nil) {
+		return errors.New("GITHUB_TOKEN or GITHUB_APP_* not set")
+	}
+	if githubRepo(cfg, b) == "" {
+		return errors.New("unknown GitHub repo")
 	}
-	return nil
+	if buildSub(b, commitSub, "") == "" {
+		return errors.New("no commit")
+	}
+	if _, ok := githubStates[b.Status]; !ok {
+		return fmt.Errorf("non-GitHub status %q", b.Status)
+	}
+	return cfg.githubFilter.check(b, ev)
 }
 
 // checkBadge returns nil if a badge image should be written for b
 // per cfg and a descriptive error otherwise.
-func (cfg *Config) checkBadge(b *cbpb.Build) error {
+func (cfg *Config) checkBadge(b *cbpb.Build, ev Event) error {
 	if cfg.badgeBucket == "" {
 		return errors.New("BADGE_BUCKET not set")
 	}
@@ -197,7 +578,10 @@ func (cfg *Config) checkBadge(b *cbpb.Build) error {
 	if _, ok := badgeStatuses[b.Status]; !ok {
 		return fmt.Errorf("non-badge status %q", b.Status)
 	}
-	return nil
+	if (buildRunning(b.Status) || buildAbandoned(b.Status)) && !cfg.badgeRunning {
+		return fmt.Errorf("status %q requires BADGE_IN_PROGRESS", b.Status)
+	}
+	return cfg.badgeFilter.check(b, ev)
 }
 
 // emailRecipientsAddrs returns a slice of bare addresses from cfg.emailRecipients.
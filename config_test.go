@@ -4,8 +4,13 @@
 package watch
 
 import (
+	"context"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -44,7 +49,7 @@ func TestLoadConfig(t *testing.T) {
 	})
 	defer undo()
 
-	cfg, err := loadConfig()
+	cfg, err := loadConfig(context.Background())
 	if err != nil {
 		t.Fatal("loadConfig failed: ", err)
 	}
@@ -68,34 +73,101 @@ func TestLoadConfig(t *testing.T) {
 		t.Errorf("Got email time zone %v; want %v", got, wantTimeZone)
 	}
 	var wantTriggerIDs = map[string]struct{}{"123-456": {}, "789-012": {}}
-	if !reflect.DeepEqual(cfg.emailBuildTriggerIDs, wantTriggerIDs) {
+	if !reflect.DeepEqual(cfg.emailFilter.triggerIDs, wantTriggerIDs) {
 		t.Errorf("Got email trigger IDs %v; want %v",
-			cfg.emailBuildTriggerIDs, wantTriggerIDs)
+			cfg.emailFilter.triggerIDs, wantTriggerIDs)
 	}
 	var wantTriggerNames = map[string]struct{}{"trigger-1": {}, "trigger-2": {}}
-	if !reflect.DeepEqual(cfg.emailBuildTriggerNames, wantTriggerNames) {
+	if !reflect.DeepEqual(cfg.emailFilter.triggerNames, wantTriggerNames) {
 		t.Errorf("Got email trigger names %v; want %v",
-			cfg.emailBuildTriggerIDs, wantTriggerNames)
+			cfg.emailFilter.triggerIDs, wantTriggerNames)
 	}
 	var wantStatuses = map[string]struct{}{"FAILURE": {}, "TIMEOUT": {}}
-	if !reflect.DeepEqual(cfg.emailBuildStatuses, wantStatuses) {
-		t.Errorf("Got email statuses %v; want %v", cfg.emailBuildStatuses, wantStatuses)
+	if !reflect.DeepEqual(cfg.emailFilter.statuses, wantStatuses) {
+		t.Errorf("Got email statuses %v; want %v", cfg.emailFilter.statuses, wantStatuses)
 	}
 }
 
 func TestLoadConfig_Defaults(t *testing.T) {
-	cfg, err := loadConfig()
+	cfg, err := loadConfig(context.Background())
 	if err != nil {
 		t.Fatal("loadConfig failed: ", err)
 	}
 	if cfg.emailPort <= 0 {
 		t.Error("No default port")
 	}
-	if len(cfg.emailBuildStatuses) <= 0 {
+	if len(cfg.emailFilter.statuses) <= 0 {
 		t.Error("No default build statuses")
 	}
 }
 
+func TestLoadConfig_SMTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-build-watcher-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hs := httptest.NewTLSServer(nil)
+	hs.Close()
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: hs.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	emptyPath := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(emptyPath, []byte("not a cert\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		env        []string
+		ok         bool   // true if loadConfig should succeed
+		auth, mode string // expected emailAuth and emailTLSMode
+		serverName string // expected emailTLSServerName
+		rootCAs    bool   // true if emailTLSRootCAs should be non-nil
+	}{
+		{nil, true, authPlain, tlsModeStartTLS, "", false},
+		{[]string{"EMAIL_AUTH=LOGIN", "EMAIL_TLS_MODE=Implicit"}, true, authLogin, tlsModeImplicit, "", false},
+		{[]string{"EMAIL_AUTH=cram-md5", "EMAIL_TLS_MODE=required"}, true, authCRAMMD5, tlsModeRequired, "", false},
+		{[]string{"EMAIL_TLS_MODE=none"}, true, authPlain, tlsModeNone, "", false},
+		{[]string{"EMAIL_TLS_SERVER_NAME=smtp.example.org", "EMAIL_TLS_CA_FILE=" + caPath},
+			true, authPlain, tlsModeStartTLS, "smtp.example.org", true},
+		{[]string{"EMAIL_AUTH=xoauth2", "EMAIL_USERNAME=user@example.org", "EMAIL_OAUTH_CLIENT_ID=id",
+			"EMAIL_OAUTH_REFRESH_TOKEN=token"}, true, authXOAUTH2, tlsModeStartTLS, "", false},
+		{[]string{"EMAIL_AUTH=xoauth2", "EMAIL_USERNAME=user@example.org",
+			"EMAIL_OAUTH_SERVICE_ACCOUNT_KEY={}"}, true, authXOAUTH2, tlsModeStartTLS, "", false},
+		{[]string{"EMAIL_AUTH=xoauth2", "EMAIL_USERNAME=user@example.org"}, false, "", "", "", false},
+		{[]string{"EMAIL_AUTH=xoauth2", "EMAIL_OAUTH_CLIENT_ID=id", "EMAIL_OAUTH_REFRESH_TOKEN=token"},
+			false, "", "", "", false},
+		{[]string{"EMAIL_AUTH=bogus"}, false, "", "", "", false},
+		{[]string{"EMAIL_TLS_MODE=bogus"}, false, "", "", "", false},
+		{[]string{"EMAIL_TLS_CA_FILE=" + filepath.Join(dir, "missing.pem")}, false, "", "", "", false},
+		{[]string{"EMAIL_TLS_CA_FILE=" + emptyPath}, false, "", "", "", false},
+	} {
+		func() {
+			defer setEnv(tc.env)()
+			cfg, err := loadConfig(context.Background())
+			if !tc.ok {
+				if err == nil {
+					t.Errorf("loadConfig with %q unexpectedly succeeded", tc.env)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("loadConfig with %q failed: %v", tc.env, err)
+				return
+			}
+			if cfg.emailAuth != tc.auth || cfg.emailTLSMode != tc.mode ||
+				cfg.emailTLSServerName != tc.serverName || (cfg.emailTLSRootCAs != nil) != tc.rootCAs {
+				t.Errorf("loadConfig with %q gave auth %q, mode %q, server name %q, root CAs %v; "+
+					"want %q, %q, %q, %v", tc.env, cfg.emailAuth, cfg.emailTLSMode,
+					cfg.emailTLSServerName, cfg.emailTLSRootCAs != nil,
+					tc.auth, tc.mode, tc.serverName, tc.rootCAs)
+			}
+		}()
+	}
+}
+
 func TestConfig_checkEmail(t *testing.T) {
 	const (
 		host  = "EMAIL_HOSTNAME=mail.example.org"
@@ -149,11 +221,217 @@ func TestConfig_checkEmail(t *testing.T) {
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			defer setEnv(tc.env)()
-			cfg, err := loadConfig()
+			cfg, err := loadConfig(context.Background())
+			if err != nil {
+				t.Fatal("loadConfig failed: ", err)
+			}
+			if err := cfg.checkEmail(tc.build, EventNone); err == nil && !tc.want {
+				t.Error("checkEmail returned nil; want an error")
+			} else if err != nil && tc.want {
+				t.Errorf("checkEmail returned %q; want nil", err)
+			}
+		})
+	}
+}
+
+func TestConfig_checkSlack(t *testing.T) {
+	const (
+		url    = "SLACK_WEBHOOK_URL=https://hooks.slack.com/services/abc"
+		names  = "SLACK_BUILD_TRIGGER_NAMES=trigger-1"
+		status = "SLACK_BUILD_STATUSES=SUCCESS"
+	)
+
+	fail := &cbpb.Build{
+		Status:        cbpb.Build_FAILURE,
+		Substitutions: map[string]string{triggerNameSub: "trigger-1"},
+	}
+	failBadName := &cbpb.Build{
+		Status:        cbpb.Build_FAILURE,
+		Substitutions: map[string]string{triggerNameSub: "bad-trigger"},
+	}
+
+	for _, tc := range []struct {
+		env   []string
+		build *cbpb.Build
+		want  bool // true for nil, false for error
+		desc  string
+	}{
+		{[]string{}, fail, false, "no config"},
+		{[]string{url}, fail, true, "default status"},
+		{[]string{url, status}, fail, false, "unmatched status"},
+		{[]string{url, names}, fail, true, "trigger name matched"},
+		{[]string{url, names}, failBadName, false, "wrong trigger name"},
+		{[]string{"EMAIL_BUILD_TRIGGER_NAMES=trigger-1", url}, failBadName, true, "email filter ignored"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setEnv(tc.env)()
+			cfg, err := loadConfig(context.Background())
+			if err != nil {
+				t.Fatal("loadConfig failed: ", err)
+			}
+			if err := cfg.checkSlack(tc.build, EventNone); err == nil && !tc.want {
+				t.Error("checkSlack returned nil; want an error")
+			} else if err != nil && tc.want {
+				t.Errorf("checkSlack returned %q; want nil", err)
+			}
+		})
+	}
+}
+
+func TestConfig_checkGitHub(t *testing.T) {
+	const (
+		token  = "GITHUB_TOKEN=pat"
+		owner  = "GITHUB_OWNER=my-org"
+		status = "GITHUB_BUILD_STATUSES=FAILURE"
+	)
+
+	success := &cbpb.Build{
+		Status:        cbpb.Build_SUCCESS,
+		Substitutions: map[string]string{commitSub: "abcdef", repoFullNameSub: "my-org/my-repo"},
+	}
+	successRepo := &cbpb.Build{
+		Status:        cbpb.Build_SUCCESS,
+		Substitutions: map[string]string{commitSub: "abcdef", repoSub: "my-repo"},
+	}
+	successNoCommit := &cbpb.Build{
+		Status:        cbpb.Build_SUCCESS,
+		Substitutions: map[string]string{repoFullNameSub: "my-org/my-repo"},
+	}
+	unknown := &cbpb.Build{
+		Status:        cbpb.Build_STATUS_UNKNOWN,
+		Substitutions: map[string]string{commitSub: "abcdef", repoFullNameSub: "my-org/my-repo"},
+	}
+
+	for _, tc := range []struct {
+		env   []string
+		build *cbpb.Build
+		want  bool // true for nil, false for error
+		desc  string
+	}{
+		{[]string{}, success, false, "no config"},
+		{[]string{token}, success, true, "full repo name"},
+		{[]string{token}, successRepo, false, "no owner"},
+		{[]string{token, owner}, successRepo, true, "owner"},
+		{[]string{token}, successNoCommit, false, "no commit"},
+		{[]string{token}, unknown, false, "unknown status"},
+		{[]string{token, status}, success, false, "unmatched status"},
+		{[]string{"GITHUB_APP_ID=123", "GITHUB_APP_INSTALLATION_ID=456"}, success, false, "no app key"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setEnv(tc.env)()
+			cfg, err := loadConfig(context.Background())
+			if err != nil {
+				t.Fatal("loadConfig failed: ", err)
+			}
+			if err := cfg.checkGitHub(tc.build, EventNone); err == nil && !tc.want {
+				t.Error("checkGitHub returned nil; want an error")
+			} else if err != nil && tc.want {
+				t.Errorf("checkGitHub returned %q; want nil", err)
+			}
+		})
+	}
+}
+
+func TestConfig_checkEmail_Events(t *testing.T) {
+	defer setEnv([]string{
+		"EMAIL_HOSTNAME=mail.example.org",
+		"EMAIL_FROM=sender@example.org",
+		"EMAIL_RECIPIENTS=recip@example.org",
+		"EMAIL_BUILD_EVENTS=BROKEN,FIXED",
+		"STATE_BUCKET=my-bucket",
+	})()
+	cfg, err := loadConfig(context.Background())
+	if err != nil {
+		t.Fatal("loadConfig failed: ", err)
+	}
+
+	success := &cbpb.Build{Status: cbpb.Build_SUCCESS}
+	fail := &cbpb.Build{Status: cbpb.Build_FAILURE}
+	for _, tc := range []struct {
+		build *cbpb.Build
+		ev    Event
+		want  bool // true for nil, false for error
+	}{
+		{fail, EventNone, false},
+		{fail, EventBroken, true},
+		{fail, EventStillFailing, false},
+		{success, EventFixed, true},
+		{success, EventStillPassing, false},
+	} {
+		if err := cfg.checkEmail(tc.build, tc.ev); err == nil && !tc.want {
+			t.Errorf("checkEmail(%v, %q) returned nil; want an error", tc.build.Status, tc.ev)
+		} else if err != nil && tc.want {
+			t.Errorf("checkEmail(%v, %q) returned %q; want nil", tc.build.Status, tc.ev, err)
+		}
+	}
+}
+
+func TestLoadConfig_BadEvent(t *testing.T) {
+	defer setEnv([]string{"EMAIL_BUILD_EVENTS=BROKEN,BOGUS"})()
+	if _, err := loadConfig(context.Background()); err == nil {
+		t.Error("loadConfig unexpectedly succeeded with bad event")
+	}
+}
+
+func TestLoadConfig_EventsWithoutState(t *testing.T) {
+	defer setEnv([]string{"SLACK_BUILD_EVENTS=BROKEN"})()
+	if _, err := loadConfig(context.Background()); err == nil {
+		t.Error("loadConfig unexpectedly succeeded with events but no STATE_BUCKET")
+	}
+}
+
+func TestLoadConfig_RequiresState(t *testing.T) {
+	for _, v := range []string{"REDELIVER_ON_ERROR=true", "DEDUPE_NOTIFICATIONS=true"} {
+		func() {
+			defer setEnv([]string{v})()
+			if _, err := loadConfig(context.Background()); err == nil {
+				t.Errorf("loadConfig unexpectedly succeeded with %v but no STATE_BUCKET", v)
+			}
+		}()
+	}
+}
+
+func TestConfig_checkEmail_Globs(t *testing.T) {
+	base := []string{
+		"EMAIL_HOSTNAME=mail.example.org",
+		"EMAIL_FROM=sender@example.org",
+		"EMAIL_RECIPIENTS=recip@example.org",
+	}
+
+	for _, tc := range []struct {
+		env          string
+		branch, repo string
+		tags         []string
+		want         bool // true for nil, false for error
+		desc         string
+	}{
+		{"EMAIL_BUILD_BRANCHES=main,release/*", "main", "repo", nil, true, "branch"},
+		{"EMAIL_BUILD_BRANCHES=main,release/*", "release/1.0", "repo", nil, true, "branch glob"},
+		{"EMAIL_BUILD_BRANCHES=main,release/*", "dev", "repo", nil, false, "unmatched branch"},
+		{"EMAIL_BUILD_EXCLUDE_BRANCHES=dev-*", "dev-1", "repo", nil, false, "excluded branch"},
+		{"EMAIL_BUILD_EXCLUDE_BRANCHES=dev-*", "main", "repo", nil, true, "non-excluded branch"},
+		{"EMAIL_BUILD_REPOS=my-*", "main", "my-repo", nil, true, "repo"},
+		{"EMAIL_BUILD_REPOS=my-*", "main", "other-repo", nil, false, "unmatched repo"},
+		{"EMAIL_BUILD_EXCLUDE_REPOS=other-*", "main", "other-repo", nil, false, "excluded repo"},
+		{"EMAIL_BUILD_TAGS=deploy", "main", "repo", []string{"a", "deploy"}, true, "tag"},
+		{"EMAIL_BUILD_TAGS=deploy", "main", "repo", []string{"a"}, false, "unmatched tag"},
+		{"EMAIL_BUILD_TAGS=deploy", "main", "repo", nil, false, "no tags"},
+		{"EMAIL_BUILD_EXCLUDE_TAGS=nightly", "main", "repo", []string{"a", "nightly"}, false, "excluded tag"},
+		{"EMAIL_BUILD_SUBSTITUTIONS=_ENV=prod,_ENV=staging", "main", "repo", nil, true, "substitution"},
+		{"EMAIL_BUILD_SUBSTITUTIONS=_ENV=dev", "main", "repo", nil, false, "unmatched substitution"},
+		{"EMAIL_BUILD_SUBSTITUTIONS=_MISSING=*", "main", "repo", nil, true, "missing substitution glob"},
+		{"EMAIL_BUILD_SUBSTITUTIONS=_MISSING=?*", "main", "repo", nil, false, "missing substitution"},
+		{"EMAIL_BUILD_EXCLUDE_SUBSTITUTIONS=_ENV=p*", "main", "repo", nil, false, "excluded substitution"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setEnv(append(base, tc.env))()
+			cfg, err := loadConfig(context.Background())
 			if err != nil {
 				t.Fatal("loadConfig failed: ", err)
 			}
-			if err := cfg.checkEmail(tc.build); err == nil && !tc.want {
+			b := makeBuild("", "", cbpb.Build_FAILURE, "", branchSub, tc.branch, repoSub, tc.repo, "_ENV", "prod")
+			b.Tags = tc.tags
+			if err := cfg.checkEmail(b, EventNone); err == nil && !tc.want {
 				t.Error("checkEmail returned nil; want an error")
 			} else if err != nil && tc.want {
 				t.Errorf("checkEmail returned %q; want nil", err)
@@ -161,3 +439,211 @@ func TestConfig_checkEmail(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_checkBadge(t *testing.T) {
+	const (
+		bucket     = "BADGE_BUCKET=my-bucket"
+		branches   = "BADGE_BUILD_BRANCHES=main"
+		inProgress = "BADGE_IN_PROGRESS=true"
+	)
+
+	for _, tc := range []struct {
+		env             []string
+		status          cbpb.Build_Status
+		trigger, branch string
+		want            bool // true for nil, false for error
+		desc            string
+	}{
+		{[]string{}, cbpb.Build_SUCCESS, "123", "main", false, "no bucket"},
+		{[]string{bucket}, cbpb.Build_SUCCESS, "", "main", false, "no trigger"},
+		{[]string{bucket}, cbpb.Build_STATUS_UNKNOWN, "123", "main", false, "non-badge status"},
+		{[]string{bucket}, cbpb.Build_WORKING, "123", "main", false, "in-progress disabled"},
+		{[]string{bucket, inProgress}, cbpb.Build_WORKING, "123", "main", true, "in-progress"},
+		{[]string{bucket, inProgress}, cbpb.Build_CANCELLED, "123", "main", true, "cancelled"},
+		{[]string{bucket}, cbpb.Build_SUCCESS, "123", "dev", true, "success"},
+		{[]string{bucket}, cbpb.Build_FAILURE, "123", "dev", true, "failure"},
+		{[]string{bucket, branches}, cbpb.Build_SUCCESS, "123", "main", true, "branch matched"},
+		{[]string{bucket, branches}, cbpb.Build_SUCCESS, "123", "dev", false, "branch unmatched"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setEnv(tc.env)()
+			cfg, err := loadConfig(context.Background())
+			if err != nil {
+				t.Fatal("loadConfig failed: ", err)
+			}
+			b := makeBuild("", tc.trigger, tc.status, "", branchSub, tc.branch)
+			if err := cfg.checkBadge(b, EventNone); err == nil && !tc.want {
+				t.Error("checkBadge returned nil; want an error")
+			} else if err != nil && tc.want {
+				t.Errorf("checkBadge returned %q; want nil", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_BadGlob(t *testing.T) {
+	defer setEnv([]string{"BADGE_BUILD_EXCLUDE_BRANCHES=main,[bad"})()
+	if _, err := loadConfig(context.Background()); err == nil {
+		t.Error("loadConfig unexpectedly succeeded with bad glob")
+	}
+}
+
+func TestConfig_checkEmail_Exclusions(t *testing.T) {
+	base := []string{
+		"EMAIL_HOSTNAME=mail.example.org",
+		"EMAIL_FROM=sender@example.org",
+		"EMAIL_RECIPIENTS=recip@example.org",
+	}
+
+	for _, tc := range []struct {
+		env           []string
+		trigger, name string // trigger ID and name
+		branch        string
+		want          string // empty for nil, otherwise substring of error
+		desc          string
+	}{
+		{[]string{"EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES=nightly-*"}, "1", "nightly-a", "main",
+			`"nightly-*" in EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES`, "excluded name"},
+		{[]string{"EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES=nightly-*"}, "1", "test", "main",
+			"", "non-excluded name"},
+		{[]string{"EMAIL_BUILD_EXCLUDE_TRIGGER_IDS=1,2"}, "2", "test", "main",
+			"EMAIL_BUILD_EXCLUDE_TRIGGER_IDS", "excluded ID"},
+		{[]string{"EMAIL_BUILD_TRIGGER_NAMES=!nightly-*"}, "1", "nightly-a", "main",
+			`"nightly-*" in EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES`, "negated name"},
+		{[]string{"EMAIL_BUILD_TRIGGER_NAMES=!nightly-*"}, "1", "test", "main",
+			"", "only negated names"},
+		{[]string{"EMAIL_BUILD_TRIGGER_IDS=!2"}, "2", "test", "main",
+			"EMAIL_BUILD_EXCLUDE_TRIGGER_IDS", "negated ID"},
+		{[]string{"EMAIL_BUILD_TRIGGER_NAMES=*,!nightly-*"}, "1", "nightly-a", "main",
+			"EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES", "exclusion beats glob"},
+		{[]string{"EMAIL_BUILD_TRIGGER_NAMES=nightly-a", "EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES=nightly-*"},
+			"1", "nightly-a", "main", "EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES", "exclusion beats name"},
+		{[]string{"EMAIL_BUILD_TRIGGER_IDS=1", "EMAIL_BUILD_TRIGGER_NAMES=!nightly-*"},
+			"1", "nightly-a", "main", "EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES", "exclusion beats ID"},
+		{[]string{"EMAIL_BUILD_TRIGGER_NAMES=test"}, "1", "other", "main",
+			"not matched by EMAIL_BUILD_TRIGGER_IDS or EMAIL_BUILD_TRIGGER_NAMES", "unmatched name"},
+		{[]string{"EMAIL_BUILD_BRANCHES=!dev-*"}, "1", "test", "dev-1",
+			`"dev-*" in EMAIL_BUILD_EXCLUDE_BRANCHES`, "negated branch"},
+		{[]string{"EMAIL_BUILD_BRANCHES=!dev-*"}, "1", "test", "main",
+			"", "only negated branches"},
+		{[]string{"EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES=nightly-?,*,nightly-*"}, "1", "nightly-a", "main",
+			`"*" in EMAIL_BUILD_EXCLUDE_TRIGGER_NAMES`, "multiple exclusions"},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			defer setEnv(append(append([]string{}, base...), tc.env...))()
+			cfg, err := loadConfig(context.Background())
+			if err != nil {
+				t.Fatal("loadConfig failed: ", err)
+			}
+			b := makeBuild("", tc.trigger, cbpb.Build_FAILURE, "", triggerNameSub, tc.name, branchSub, tc.branch)
+			err = cfg.checkEmail(b, EventNone)
+			if err == nil && tc.want != "" {
+				t.Errorf("checkEmail returned nil; want error containing %q", tc.want)
+			} else if err != nil && tc.want == "" {
+				t.Errorf("checkEmail returned %q; want nil", err)
+			} else if err != nil && !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("checkEmail returned %q; want error containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_BadgeTemplates(t *testing.T) {
+	for _, tc := range []struct {
+		env []string
+		ok  bool // true if loadConfig should succeed
+	}{
+		{[]string{"BADGE_BRANCH_TEMPLATE={{.TriggerName}}/{{.Branch}}.svg"}, true},
+		{[]string{"BADGE_BRANCH_TEMPLATE={{.TriggerName"}, false},
+		{[]string{"BADGE_BRANCH_TEMPLATE={{.Bogus}}.svg"}, false},
+		{[]string{"BADGE_OBJECT_TEMPLATE={{.Project}}/{{.Repo}}/{{.TriggerName}}.svg"}, true},
+		{[]string{"BADGE_OBJECT_TEMPLATE={{.TriggerName}}.svg", "BADGE_KEEP_ID_NAME=true"}, true},
+		{[]string{"BADGE_OBJECT_TEMPLATE={{.TriggerName}}/"}, false},
+		{[]string{"BADGE_OBJECT_TEMPLATE=.well-known/acme-challenge/{{.TriggerName}}.svg"}, false},
+		{[]string{"BADGE_OBJECT_TEMPLATE={{.TriggerName}}"}, false}, // no extension
+		{[]string{"BADGE_OBJECT_TEMPLATE={{.Bogus}}.svg"}, false},
+	} {
+		func() {
+			defer setEnv(tc.env)()
+			if _, err := loadConfig(context.Background()); err != nil && tc.ok {
+				t.Errorf("loadConfig with %q failed: %v", tc.env, err)
+			} else if err == nil && !tc.ok {
+				t.Errorf("loadConfig with %q unexpectedly succeeded", tc.env)
+			}
+		}()
+	}
+}
+
+func TestLoadConfig_BadgeTheme(t *testing.T) {
+	for _, tc := range []struct {
+		env []string
+		ok  bool // true if loadConfig should succeed
+	}{
+		{[]string{"BADGE_PALETTE=shields", "BADGE_LABEL=tests", "BADGE_LABEL_COLORS=#333/#fff",
+			"BADGE_TRIGGER_LABELS=deploy-prod=deploy", "BADGE_STATUS_TEXT=SUCCESS=passing",
+			"BADGE_STATUS_COLORS=FAILURE=#f00,TIMEOUT=#000/#ff0"}, true},
+		{[]string{"BADGE_PALETTE=bogus"}, false},
+		{[]string{"BADGE_LABEL_COLORS=gray"}, false},
+		{[]string{"BADGE_STATUS_TEXT=BOGUS=foo"}, false},
+		{[]string{"BADGE_STATUS_COLORS=SUCCESS=green"}, false},
+		{[]string{"BADGE_STYLE=for-the-badge"}, true},
+		{[]string{"BADGE_STYLE=bogus"}, false},
+		{[]string{"BADGE_BUCKET=bucket", "BADGE_ENDPOINT_JSON=1"}, true},
+		{[]string{"BADGE_ENDPOINT_JSON=1"}, false}, // requires BADGE_BUCKET
+		{[]string{"BADGE_BUCKET=bucket", "BADGE_PNG=1"}, true},
+		{[]string{"BADGE_PNG=1"}, false}, // requires BADGE_BUCKET
+		{[]string{"BADGE_BUCKET=bucket", "BADGE_INDEX=1"}, true},
+		{[]string{"BADGE_INDEX=1"}, false}, // requires BADGE_BUCKET
+	} {
+		func() {
+			defer setEnv(tc.env)()
+			if _, err := loadConfig(context.Background()); err != nil && tc.ok {
+				t.Errorf("loadConfig with %q failed: %v", tc.env, err)
+			} else if err == nil && !tc.ok {
+				t.Errorf("loadConfig with %q unexpectedly succeeded", tc.env)
+			}
+		}()
+	}
+}
+
+func TestLoadConfig_BadgeHistory(t *testing.T) {
+	const bucket = "BADGE_BUCKET=my-bucket"
+	for _, tc := range []struct {
+		env  []string
+		want objectStore // nil if loadConfig should fail
+	}{
+		{[]string{bucket}, nil},
+		{[]string{bucket, "BADGE_TREND=1"}, &gcsStore{bucket: "my-bucket", prefix: "history/"}},
+		{[]string{bucket, "BADGE_REPORTS=1"}, nil},
+		{[]string{bucket, "BADGE_REPORTS=1", "BADGE_REPORT_HISTORY=1"},
+			&gcsStore{bucket: "my-bucket", prefix: "history/"}},
+		{[]string{bucket, "BADGE_HISTORY=gs://other/hist"}, &gcsStore{bucket: "other", prefix: "hist/"}},
+		{[]string{bucket, "BADGE_HISTORY=gs://other"}, &gcsStore{bucket: "other"}},
+		{[]string{bucket, "BADGE_HISTORY=/var/history"}, &dirStore{dir: "/var/history"}},
+	} {
+		func() {
+			defer setEnv(tc.env)()
+			cfg, err := loadConfig(context.Background())
+			if err != nil {
+				t.Errorf("loadConfig with %q failed: %v", tc.env, err)
+			} else if !reflect.DeepEqual(cfg.badgeHistory, tc.want) {
+				t.Errorf("loadConfig with %q set history %+v; want %+v", tc.env, cfg.badgeHistory, tc.want)
+			}
+		}()
+	}
+
+	for _, env := range [][]string{
+		{"BADGE_TREND=1"},                  // requires BADGE_BUCKET
+		{"BADGE_DURATION=1"},               // requires BADGE_BUCKET
+		{bucket, "BADGE_HISTORY_SIZE=0"},   // must be positive
+		{bucket, "BADGE_REPORT_HISTORY=1"}, // requires BADGE_REPORTS
+		{bucket, "BADGE_HISTORY=gs:///x"},  // no bucket
+	} {
+		func() {
+			defer setEnv(env)()
+			if _, err := loadConfig(context.Background()); err == nil {
+				t.Errorf("loadConfig with %q unexpectedly succeeded", env)
+			}
+		}()
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// dedupeLease is how long a notifier's claim on a build remains valid before another
+// invocation is allowed to take it over. It exceeds the maximum Cloud Function timeout.
+const dedupeLease = 10 * time.Minute
+
+const (
+	// Values for dedupeMarker.State.
+	dedupePending = "pending" // notifier is running
+	dedupeDone    = "done"    // notifier succeeded
+	dedupeFailed  = "failed"  // notifier failed and can be retried
+)
+
+// dedupeMarker is the JSON-marshaled content of objects recording that a notifier
+// was run for a build's status.
+type dedupeMarker struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"` // when State was set
+}
+
+// dedupeNotifier wraps a notifier and records notifications in an objectStore so that
+// each notifier acts at most once per build ID and status, even if Pub/Sub delivers
+// a message multiple times (possibly concurrently). A notifier claims a build by
+// creating a pending marker before running; if it fails, the marker is released so
+// that a redelivered message can retry it.
+//
+// Marker objects are never deleted; an object lifecycle rule can be used to clean them up.
+type dedupeNotifier struct {
+	notifier
+	st        objectStore
+	redeliver bool // return an error for builds claimed by other invocations
+}
+
+func (n *dedupeNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	name := dedupeName(n, b)
+	now := timeNow()
+	var prev string // previous marker state if we didn't get a claim
+	if err := updateObject(ctx, n.st, name, func(old *object) (*object, error) {
+		prev = ""
+		if old != nil {
+			var m dedupeMarker
+			if err := json.Unmarshal(old.data, &m); err != nil {
+				return nil, err
+			}
+			if m.State == dedupeDone || (m.State == dedupePending && now.Sub(m.Time) < dedupeLease) {
+				prev = m.State
+				return nil, nil
+			}
+		}
+		return newDedupeObject(dedupePending, now)
+	}); err != nil {
+		return err
+	}
+	if prev == dedupePending && n.redeliver {
+		// The invocation holding the claim may have crashed, so ask Pub/Sub to redeliver the
+		// message so the notifier can be retried after the lease expires.
+		return fmt.Errorf("%v notifier already pending for build %v with status %v",
+			n.name(), b.Id, b.Status)
+	} else if prev != "" {
+		log.Printf("Not running %v notifier for build %v with status %v: already %v",
+			n.name(), b.Id, b.Status, prev)
+		return nil
+	}
+
+	nerr := n.notifier.notify(ctx, b, ev)
+	state := dedupeDone
+	if nerr != nil {
+		state = dedupeFailed
+	}
+	obj, err := newDedupeObject(state, timeNow())
+	if err != nil {
+		return err
+	}
+	if err := n.st.write(ctx, name, obj, anyGen); err != nil && nerr == nil {
+		return err
+	}
+	return nerr
+}
+
+// dedupeName returns the name of the object recording that n was run for b's current status.
+func dedupeName(n notifier, b *cbpb.Build) string {
+	return "notified/" + url.PathEscape(b.Id) + "/" + b.Status.String() + "/" + url.PathEscape(n.name())
+}
+
+// newDedupeObject returns an object containing a dedupeMarker with the supplied values.
+func newDedupeObject(state string, t time.Time) (*object, error) {
+	data, err := json.Marshal(&dedupeMarker{state, t.UTC()})
+	if err != nil {
+		return nil, err
+	}
+	return &object{data: data, contentType: "application/json"}, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestDedupeNotifier(t *testing.T) {
+	ctx := context.Background()
+	inner := &fakeNotifier{notifyErr: errors.New("failed")}
+	n := &dedupeNotifier{inner, newMemStore(), false}
+	b := &cbpb.Build{Id: "build-id", Status: cbpb.Build_FAILURE}
+
+	// The build should be passed to the inner notifier until it succeeds.
+	if err := n.notify(ctx, b, EventNone); err == nil {
+		t.Error("notify didn't return inner notifier's error")
+	}
+	inner.notifyErr = nil
+	if err := n.notify(ctx, b, EventNone); err != nil {
+		t.Error("notify failed: ", err)
+	}
+	if err := n.notify(ctx, b, EventNone); err != nil {
+		t.Error("notify failed for redelivered build: ", err)
+	}
+	if want := []string{"build-id", "build-id"}; !reflect.DeepEqual(inner.got, want) {
+		t.Errorf("Inner notifier got %v; want %v", inner.got, want)
+	}
+
+	// A new status should be passed through.
+	b.Status = cbpb.Build_SUCCESS
+	if err := n.notify(ctx, b, EventNone); err != nil {
+		t.Error("notify failed: ", err)
+	}
+	if got, want := len(inner.got), 3; got != want {
+		t.Errorf("Inner notifier got %d build(s); want %d", got, want)
+	}
+}
+
+func TestDedupeNotifier_Pending(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 12, 11, 19, 42, 31, 0, time.UTC)
+	origNow := timeNow
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = origNow }()
+
+	inner := &fakeNotifier{}
+	st := newMemStore()
+	n := &dedupeNotifier{inner, st, false}
+	b := &cbpb.Build{Id: "build-id", Status: cbpb.Build_FAILURE}
+
+	// Simulate another invocation having claimed the build.
+	obj, err := newDedupeObject(dedupePending, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.write(ctx, dedupeName(n, b), obj, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.notify(ctx, b, EventNone); err != nil {
+		t.Error("notify failed: ", err)
+	}
+	if len(inner.got) != 0 {
+		t.Errorf("Inner notifier got %v while build was claimed", inner.got)
+	}
+	// When messages are redelivered on error, an error should be returned so that Pub/Sub
+	// retries the build in case the invocation holding the claim died.
+	n.redeliver = true
+	if err := n.notify(ctx, b, EventNone); err == nil {
+		t.Error("notify didn't return error for claimed build with redelivery enabled")
+	}
+	if len(inner.got) != 0 {
+		t.Errorf("Inner notifier got %v while build was claimed", inner.got)
+	}
+
+	// After the claim expires, the build should be taken over.
+	now = now.Add(dedupeLease)
+	if err := n.notify(ctx, b, EventNone); err != nil {
+		t.Error("notify failed: ", err)
+	}
+	if want := []string{"build-id"}; !reflect.DeepEqual(inner.got, want) {
+		t.Errorf("Inner notifier got %v after claim expired; want %v", inner.got, want)
+	}
+}
+
+// countingNotifier is a notifier that counts calls to notify.
+type countingNotifier struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (n *countingNotifier) name() string                        { return "counting" }
+func (n *countingNotifier) check(b *cbpb.Build, ev Event) error { return nil }
+func (n *countingNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	n.mu.Lock()
+	n.count++
+	n.mu.Unlock()
+	return nil
+}
+
+func TestDedupeNotifier_Concurrent(t *testing.T) {
+	inner := &countingNotifier{}
+	n := &dedupeNotifier{inner, newMemStore(), false}
+	b := &cbpb.Build{Id: "build-id", Status: cbpb.Build_FAILURE}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := n.notify(context.Background(), b, EventNone); err != nil {
+				t.Error("notify failed: ", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if inner.count != 1 {
+		t.Errorf("Inner notifier was called %d times; want 1", inner.count)
+	}
+}
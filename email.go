@@ -10,7 +10,6 @@ import (
 	"io"
 	"log"
 	"mime/multipart"
-	"net/smtp"
 	"net/textproto"
 	"strings"
 	htemplate "text/template"
@@ -20,27 +19,43 @@ import (
 	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
 )
 
+// timeNow returns the current time. It is replaced by tests.
+var timeNow = time.Now
+
+// emailRetryDelay is the delay before retrying after a temporary SMTP error.
+// It is a variable so it can be replaced by tests.
+var emailRetryDelay = 5 * time.Second
+
+// emailNotifier implements notifier by sending email messages.
+type emailNotifier struct{ cfg *Config }
+
+func (n *emailNotifier) name() string                        { return n.cfg.notifierName("email") }
+func (n *emailNotifier) check(b *cbpb.Build, ev Event) error { return n.cfg.checkEmail(b, ev) }
+func (n *emailNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	return sendEmail(ctx, n.cfg, b, ev)
+}
+
 // sendEmail sends an email message describing build per cfg.
 // cfg.checkEmail must be called first to check that email should actually be sent.
-func sendEmail(ctx context.Context, cfg *Config, build *cbpb.Build) error {
-	msg, err := BuildEmail(cfg, build)
+func sendEmail(ctx context.Context, cfg *Config, build *cbpb.Build, ev Event) error {
+	msg, err := BuildEmail(cfg, build, ev)
 	if err != nil {
 		return fmt.Errorf("building email: %v", err)
 	}
 
-	addr := fmt.Sprintf("%s:%d", cfg.emailHostname, cfg.emailPort)
-	var auth smtp.Auth
-	if cfg.emailUsername != "" {
-		auth = smtp.PlainAuth("", cfg.emailUsername, cfg.emailPassword, cfg.emailHostname)
-	}
-
 	log.Printf("Sending email to %v", strings.Join(cfg.emailRecipientsAddrs(), ","))
-	return smtp.SendMail(addr, auth, cfg.emailFrom.Address, cfg.emailRecipientsAddrs(), msg)
+	return retry(ctx, cfg.emailRetries, emailRetryDelay, func() error {
+		err := sendMail(ctx, cfg, cfg.emailFrom.Address, cfg.emailRecipientsAddrs(), msg)
+		if err != nil && !isTemporarySMTPError(err) {
+			return &permanentError{err}
+		}
+		return err
+	})
 }
 
-// BuildEmail constructs an email message describing build per cfg.
+// BuildEmail constructs an email message describing build and ev per cfg.
 // It is exported so it can be used by the test_email program.
-func BuildEmail(cfg *Config, build *cbpb.Build) ([]byte, error) {
+func BuildEmail(cfg *Config, build *cbpb.Build, ev Event) ([]byte, error) {
 	var b bytes.Buffer
 	mw := multipart.NewWriter(&b)
 
@@ -49,11 +64,8 @@ func BuildEmail(cfg *Config, build *cbpb.Build) ([]byte, error) {
 	writeHead("From", cfg.emailFrom.String())
 	// TODO: Preserve names instead of just using addresses?
 	writeHead("To", strings.Join(cfg.emailRecipientsAddrs(), ", "))
-	writeHead("Subject",
-		fmt.Sprintf("[%s] %s %s (build %s)", build.ProjectId,
-			buildSub(build, triggerNameSub, "[unknown]"),
-			build.Status, strings.Split(build.Id, "-")[0]))
-	writeHead("Date", time.Now().In(cfg.emailTimeZone).Format(time.RFC1123Z))
+	writeHead("Subject", buildSummary(build, ev))
+	writeHead("Date", timeNow().In(cfg.emailTimeZone).Format(time.RFC1123Z))
 	writeHead("MIME-Version", "1.0")
 	writeHead("Content-Type", "multipart/alternative; boundary="+mw.Boundary())
 	io.WriteString(&b, "\r\n")
@@ -68,36 +80,7 @@ func BuildEmail(cfg *Config, build *cbpb.Build) ([]byte, error) {
 		return f(pw)
 	}
 
-	const timeFmt = time.RFC1123Z // "Mon, 02 Jan 2006 15:04:05 -0700"
-	start := build.StartTime.AsTime()
-	end := build.FinishTime.AsTime()
-	tdata := struct {
-		BuildID     string
-		LogURL      string
-		TriggerID   string
-		TriggerName string
-		TriggerURL  string
-		Status      string
-		Repo        string
-		Commit      string
-		Branch      string
-		Start       string
-		End         string
-		Duration    string
-	}{
-		BuildID:     build.Id,
-		LogURL:      build.LogUrl,
-		TriggerID:   build.BuildTriggerId,
-		TriggerName: buildSub(build, triggerNameSub, ""),
-		TriggerURL:  "https://console.cloud.google.com/cloud-build/triggers/edit/" + build.BuildTriggerId,
-		Status:      build.Status.String(),
-		Repo:        buildSub(build, repoSub, ""),
-		Commit:      buildSub(build, commitSub, ""),
-		Branch:      buildSub(build, branchSub, ""),
-		Start:       start.In(cfg.emailTimeZone).Format(timeFmt),
-		End:         end.In(cfg.emailTimeZone).Format(timeFmt),
-		Duration:    formatDuration(end.Sub(start)),
-	}
+	tdata := newBuildData(build, cfg.emailTimeZone)
 
 	// Add plain text part.
 	if err := writeBody("text/plain; charset=UTF-8", func(w io.Writer) error {
@@ -23,6 +23,26 @@ func makeTimestamp(s string) *tspb.Timestamp {
 	return tspb.New(tt)
 }
 
+// makeBuild returns a build with the supplied ID, trigger ID, and status.
+// created is an RFC 3339 creation time and may be empty to leave it unset.
+// subs contains alternating substitution names and values.
+func makeBuild(id, trigger string, status cbpb.Build_Status, created string, subs ...string) *cbpb.Build {
+	if len(subs)%2 != 0 {
+		log.Panicf("Odd number of substitution args %q", subs)
+	}
+	b := &cbpb.Build{Id: id, BuildTriggerId: trigger, Status: status}
+	if created != "" {
+		b.CreateTime = makeTimestamp(created)
+	}
+	if len(subs) > 0 {
+		b.Substitutions = make(map[string]string, len(subs)/2)
+		for i := 0; i < len(subs); i += 2 {
+			b.Substitutions[subs[i]] = subs[i+1]
+		}
+	}
+	return b
+}
+
 func TestBuildEmail(t *testing.T) {
 	cfg := &Config{
 		emailFrom: &mail.Address{Name: "Sender Name", Address: "sender@example.org"},
@@ -52,7 +72,11 @@ func TestBuildEmail(t *testing.T) {
 		},
 	}
 
-	msg, err := BuildEmail(cfg, build)
+	origNow := timeNow
+	timeNow = func() time.Time { return build.FinishTime.AsTime() }
+	defer func() { timeNow = origNow }()
+
+	msg, err := BuildEmail(cfg, build, EventNone)
 	if err != nil {
 		t.Fatal("BuildEmail failed: ", err)
 	}
@@ -107,3 +131,25 @@ func TestFormatDuration(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildEmail_Fixed(t *testing.T) {
+	cfg := &Config{
+		emailFrom:       &mail.Address{Address: "sender@example.org"},
+		emailRecipients: []*mail.Address{&mail.Address{Address: "user@example.org"}},
+		emailTimeZone:   time.UTC,
+	}
+	build := &cbpb.Build{
+		Id:            "1234-5678",
+		ProjectId:     "my-project",
+		Status:        cbpb.Build_SUCCESS,
+		Substitutions: map[string]string{triggerNameSub: "my-trigger"},
+	}
+	msg, err := BuildEmail(cfg, build, EventFixed)
+	if err != nil {
+		t.Fatal("BuildEmail failed: ", err)
+	}
+	const re = `Subject: \[my-project\] my-trigger fixed \(build 1234\)\r\n`
+	if !regexp.MustCompile(re).Match(msg) {
+		t.Errorf("BuildEmail output not matched by %q:\n%s", re, msg)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// buildFilter decides which builds a notifier should act on.
+//
+// Exclusions take precedence over inclusions: a build is rejected if any of its values
+// is matched by an exclude set, even if it is also matched by the corresponding include set.
+// Otherwise, it is rejected if it isn't matched by a non-empty include set.
+// Patterns prefixed with '!' in include sets are moved to the corresponding exclude sets
+// by splitNegated, so an include set containing only negated patterns matches everything
+// that isn't excluded.
+type buildFilter struct {
+	prefix       string              // prefix for field names in errors, e.g. "EMAIL_BUILD_"
+	triggerIDs   map[string]struct{} // Cloud Build trigger IDs, empty to not check
+	triggerNames map[string]struct{} // Cloud Build trigger names or globs, empty to not check
+	statuses     map[string]struct{} // Cloud Build statuses, e.g. "FAILURE", empty to not check
+	events       map[string]struct{} // events, e.g. "BROKEN" or "FIXED", empty to not check
+	branches     map[string]struct{} // branch name globs, empty to not check
+	repos        map[string]struct{} // repo name globs, empty to not check
+	tags         map[string]struct{} // build tag globs matched against any tag, empty to not check
+
+	excludeTriggerIDs   map[string]struct{} // Cloud Build trigger IDs to reject
+	excludeTriggerNames map[string]struct{} // Cloud Build trigger names or globs to reject
+	excludeBranches     map[string]struct{} // branch name globs to reject
+	excludeRepos        map[string]struct{} // repo name globs to reject
+	excludeTags         map[string]struct{} // build tag globs to reject if they match any tag
+
+	// Globs matched against arbitrary substitutions, keyed by substitution name.
+	substitutions        map[string]map[string]struct{}
+	excludeSubstitutions map[string]map[string]struct{}
+}
+
+// check returns nil if b and ev are matched by f and a descriptive error otherwise.
+func (f *buildFilter) check(b *cbpb.Build, ev Event) error {
+	name := buildSub(b, triggerNameSub, "")
+	if _, ok := f.excludeTriggerIDs[b.BuildTriggerId]; ok {
+		return fmt.Errorf("trigger ID %v matched by exclusion in %sEXCLUDE_TRIGGER_IDS",
+			b.BuildTriggerId, f.prefix)
+	}
+	if p, ok := findGlob(f.excludeTriggerNames, name); ok {
+		return fmt.Errorf("trigger name %q matched by exclusion %q in %sEXCLUDE_TRIGGER_NAMES",
+			name, p, f.prefix)
+	}
+	if len(f.triggerIDs) > 0 || len(f.triggerNames) > 0 {
+		_, idOk := f.triggerIDs[b.BuildTriggerId]
+		_, nameOk := f.triggerNames[name]
+		if !idOk && !nameOk && !matchGlobs(f.triggerNames, name) {
+			return fmt.Errorf("trigger %v (%q) not matched by %sTRIGGER_IDS or %sTRIGGER_NAMES",
+				b.BuildTriggerId, name, f.prefix, f.prefix)
+		}
+	}
+	if _, ok := f.statuses[b.Status.String()]; !ok && len(f.statuses) > 0 {
+		return fmt.Errorf("status %q not matched by %sSTATUSES", b.Status, f.prefix)
+	}
+	if _, ok := f.events[string(ev)]; !ok && len(f.events) > 0 {
+		return fmt.Errorf("event %q not matched by %sEVENTS", ev, f.prefix)
+	}
+	if err := f.checkValues("branch", "BRANCHES", []string{buildSub(b, branchSub, "")},
+		f.branches, f.excludeBranches); err != nil {
+		return err
+	}
+	if err := f.checkValues("repo", "REPOS", []string{buildSub(b, repoSub, "")},
+		f.repos, f.excludeRepos); err != nil {
+		return err
+	}
+	if err := f.checkValues("tags", "TAGS", b.Tags, f.tags, f.excludeTags); err != nil {
+		return err
+	}
+	for _, m := range []map[string]map[string]struct{}{f.substitutions, f.excludeSubstitutions} {
+		for name := range m {
+			if err := f.checkValues("substitution "+name, "SUBSTITUTIONS",
+				[]string{buildSub(b, name, "")}, f.substitutions[name],
+				f.excludeSubstitutions[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkValues returns an error if include is non-empty and none of vals are matched by it,
+// or if any of vals are matched by exclude. desc describes vals and field is used to
+// construct the names of the include and exclude fields in error messages.
+func (f *buildFilter) checkValues(desc, field string, vals []string,
+	include, exclude map[string]struct{}) error {
+	var included bool
+	for _, v := range vals {
+		if p, ok := findGlob(exclude, v); ok {
+			return fmt.Errorf("%s %q matched by exclusion %q in %sEXCLUDE_%s", desc, v, p, f.prefix, field)
+		}
+		if matchGlobs(include, v) {
+			included = true
+		}
+	}
+	if len(include) > 0 && !included {
+		if len(vals) == 1 {
+			return fmt.Errorf("%s %q not matched by %s%s", desc, vals[0], f.prefix, field)
+		}
+		return fmt.Errorf("%s %q not matched by %s%s", desc, vals, f.prefix, field)
+	}
+	return nil
+}
+
+// validate returns an error if f contains invalid values.
+func (f *buildFilter) validate() error {
+	for s := range f.statuses {
+		if _, ok := cbpb.Build_Status_value[s]; !ok {
+			return fmt.Errorf("bad status %q in %sSTATUSES", s, f.prefix)
+		}
+	}
+	for ev := range f.events {
+		if _, ok := validEvents[Event(ev)]; !ok {
+			return fmt.Errorf("bad event %q in %sEVENTS", ev, f.prefix)
+		}
+	}
+	globs := []map[string]struct{}{f.triggerNames, f.branches, f.repos, f.tags,
+		f.excludeTriggerNames, f.excludeBranches, f.excludeRepos, f.excludeTags}
+	for _, m := range []map[string]map[string]struct{}{f.substitutions, f.excludeSubstitutions} {
+		for _, g := range m {
+			globs = append(globs, g)
+		}
+	}
+	for _, g := range globs {
+		for p := range g {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("bad glob %q in %s filter", p, f.prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// splitNegated moves '!'-prefixed patterns from f's include sets to the corresponding
+// exclude sets, e.g. "!nightly-*" in triggerNames becomes "nightly-*" in excludeTriggerNames.
+func (f *buildFilter) splitNegated() {
+	move := func(include map[string]struct{}, exclude *map[string]struct{}) {
+		for p := range include {
+			if !strings.HasPrefix(p, "!") {
+				continue
+			}
+			delete(include, p)
+			if *exclude == nil {
+				*exclude = make(map[string]struct{})
+			}
+			(*exclude)[p[1:]] = struct{}{}
+		}
+	}
+	move(f.triggerIDs, &f.excludeTriggerIDs)
+	move(f.triggerNames, &f.excludeTriggerNames)
+	move(f.branches, &f.excludeBranches)
+	move(f.repos, &f.excludeRepos)
+	move(f.tags, &f.excludeTags)
+	for name, include := range f.substitutions {
+		exclude := f.excludeSubstitutions[name]
+		move(include, &exclude)
+		if exclude != nil {
+			if f.excludeSubstitutions == nil {
+				f.excludeSubstitutions = make(map[string]map[string]struct{})
+			}
+			f.excludeSubstitutions[name] = exclude
+		}
+	}
+}
+
+// matchGlobs returns true if s is matched by any of the glob patterns in globs.
+func matchGlobs(globs map[string]struct{}, s string) bool {
+	_, ok := findGlob(globs, s)
+	return ok
+}
+
+// findGlob returns the lexicographically-first glob pattern in globs that matches s.
+// Patterns are sorted so that errors consistently report the same pattern.
+func findGlob(globs map[string]struct{}, s string) (pattern string, ok bool) {
+	patterns := make([]string, 0, len(globs))
+	for p := range globs {
+		patterns = append(patterns, p)
+	}
+	sort.Strings(patterns)
+	for _, p := range patterns {
+		if m, err := filepath.Match(p, s); err == nil && m {
+			return p, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// githubStates maps from Cloud Build statuses to GitHub commit status states.
+// Statuses not listed here are not reported.
+var githubStates = map[cbpb.Build_Status]string{
+	cbpb.Build_QUEUED:         "pending",
+	cbpb.Build_WORKING:        "pending",
+	cbpb.Build_SUCCESS:        "success",
+	cbpb.Build_FAILURE:        "failure",
+	cbpb.Build_INTERNAL_ERROR: "error",
+	cbpb.Build_TIMEOUT:        "error",
+	cbpb.Build_CANCELLED:      "error",
+	cbpb.Build_EXPIRED:        "error",
+}
+
+// githubNotifier implements notifier by setting GitHub commit statuses.
+type githubNotifier struct{ cfg *Config }
+
+func (n *githubNotifier) name() string                        { return "GitHub" }
+func (n *githubNotifier) check(b *cbpb.Build, ev Event) error { return n.cfg.checkGitHub(b, ev) }
+func (n *githubNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	return setGitHubStatus(ctx, n.cfg, b)
+}
+
+// githubRepo returns the "owner/repo" name of the GitHub repository that b was built from,
+// or an empty string if it can't be determined.
+func githubRepo(cfg *Config, b *cbpb.Build) string {
+	if v := buildSub(b, repoFullNameSub, ""); v != "" {
+		return v
+	}
+	if repo := buildSub(b, repoSub, ""); repo != "" && cfg.githubOwner != "" {
+		return cfg.githubOwner + "/" + repo
+	}
+	return ""
+}
+
+// setGitHubStatus sets a status describing build on the GitHub commit that it built.
+// cfg.checkGitHub must be called first to check that the status should actually be set.
+func setGitHubStatus(ctx context.Context, cfg *Config, build *cbpb.Build) error {
+	state, ok := githubStates[build.Status]
+	if !ok {
+		return fmt.Errorf("no GitHub state for status %q", build.Status)
+	}
+
+	token := cfg.githubToken
+	if token == "" {
+		var err error
+		if token, err = getGitHubAppToken(ctx, cfg); err != nil {
+			return fmt.Errorf("getting app token: %v", err)
+		}
+	}
+
+	statusCtx := cfg.githubContext
+	if name := buildSub(build, triggerNameSub, ""); name != "" {
+		statusCtx += "/" + name
+	}
+	desc := "Build " + build.Status.String()
+	if build.StartTime != nil && build.FinishTime != nil {
+		desc += " after " + formatDuration(build.FinishTime.AsTime().Sub(build.StartTime.AsTime()))
+	}
+
+	repo := githubRepo(cfg, build)
+	commit := buildSub(build, commitSub, "")
+	log.Printf("Setting GitHub status %q for %v commit %v", state, repo, commit)
+	return doGitHubRequest(ctx, http.MethodPost,
+		fmt.Sprintf("%s/repos/%s/statuses/%s", cfg.githubAPIURL, repo, commit), "token "+token,
+		struct {
+			State       string `json:"state"`
+			TargetURL   string `json:"target_url,omitempty"`
+			Description string `json:"description"`
+			Context     string `json:"context"`
+		}{state, build.LogUrl, desc, statusCtx}, nil)
+}
+
+// getGitHubAppToken returns an installation access token for the GitHub App described by cfg.
+// See https://docs.github.com/en/developers/apps/building-github-apps/authenticating-with-github-apps.
+func getGitHubAppToken(ctx context.Context, cfg *Config) (string, error) {
+	now := timeNow().Unix()
+	jwt, err := makeJWT(cfg.githubAppKey, map[string]interface{}{
+		"iat": now - 60, // allow for clock drift
+		"exp": now + 9*60,
+		"iss": cfg.githubAppID,
+	})
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := doGitHubRequest(ctx, http.MethodPost,
+		fmt.Sprintf("%s/app/installations/%d/access_tokens", cfg.githubAPIURL, cfg.githubAppInstallID),
+		"Bearer "+jwt, nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.Token == "" {
+		return "", errors.New("no token in response")
+	}
+	return resp.Token, nil
+}
+
+// githubTimeout is the timeout for each GitHub API request.
+const githubTimeout = 10 * time.Second
+
+// doGitHubRequest sends an HTTP request to the GitHub REST API with the supplied
+// Authorization header. If reqBody is non-nil, it is marshaled to JSON and sent as the body.
+// If respBody is non-nil, the response is unmarshaled into it.
+func doGitHubRequest(ctx context.Context, method, url, auth string,
+	reqBody, respBody interface{}) error {
+	var body io.Reader
+	if reqBody != nil {
+		b, err := json.Marshal(reqBody)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", auth)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := http.Client{Timeout: githubTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("got %v: %q", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if respBody != nil {
+		return json.NewDecoder(resp.Body).Decode(respBody)
+	}
+	return nil
+}
+
+// makeJWT returns a JSON Web Token containing claims and signed with key using RS256.
+func makeJWT(key *rsa.PrivateKey, claims map[string]interface{}) (string, error) {
+	enc := func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b), err
+	}
+	head, err := enc(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	body, err := enc(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := head + "." + body
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parseRSAKey parses a PEM-encoded PKCS #1 or PKCS #8 RSA private key.
+func parseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("got %T instead of RSA key", key)
+	}
+	return rsaKey, nil
+}
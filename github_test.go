@@ -0,0 +1,166 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// fakeGitHub is an httptest server implementing a subset of GitHub's REST API.
+type fakeGitHub struct {
+	*httptest.Server
+	t        *testing.T
+	appKey   *rsa.PublicKey // used to verify app JWTs
+	appToken string         // installation token returned to apps
+	auth     string         // Authorization header from last status request
+	path     string         // path from last status request
+	status   map[string]string
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	gh := &fakeGitHub{t: t, appToken: "app-token"}
+	gh.Server = httptest.NewServer(gh)
+	return gh
+}
+
+func (gh *fakeGitHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == http.MethodPost && req.URL.Path == "/app/installations/456/access_tokens":
+		if err := gh.checkJWT(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")); err != nil {
+			gh.t.Error("Bad app JWT: ", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": gh.appToken})
+	case req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/repos/"):
+		gh.auth = req.Header.Get("Authorization")
+		gh.path = req.URL.Path
+		gh.status = nil
+		if err := json.NewDecoder(req.Body).Decode(&gh.status); err != nil {
+			gh.t.Error("Failed decoding status: ", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// checkJWT verifies that jwt was signed by gh.appKey.
+func (gh *fakeGitHub) checkJWT(jwt string) error {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("got %d part(s)", len(parts))
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return rsa.VerifyPKCS1v15(gh.appKey, crypto.SHA256, sum[:], sig)
+}
+
+func TestSetGitHubStatus_Token(t *testing.T) {
+	gh := newFakeGitHub(t)
+	defer gh.Close()
+
+	cfg := &Config{githubAPIURL: gh.URL, githubToken: "pat", githubContext: "cloud-build"}
+	build := &cbpb.Build{
+		Status:     cbpb.Build_FAILURE,
+		LogUrl:     "https://example.org/log",
+		StartTime:  makeTimestamp("2021-12-11T19:42:31Z"),
+		FinishTime: makeTimestamp("2021-12-11T20:04:51Z"),
+		Substitutions: map[string]string{
+			commitSub:       "abcdef",
+			repoFullNameSub: "my-org/my-repo",
+			triggerNameSub:  "my-trigger",
+		},
+	}
+	if err := setGitHubStatus(context.Background(), cfg, build); err != nil {
+		t.Fatal("setGitHubStatus failed: ", err)
+	}
+	if want := "token pat"; gh.auth != want {
+		t.Errorf("Got Authorization %q; want %q", gh.auth, want)
+	}
+	if want := "/repos/my-org/my-repo/statuses/abcdef"; gh.path != want {
+		t.Errorf("Got path %q; want %q", gh.path, want)
+	}
+	for k, want := range map[string]string{
+		"state":       "failure",
+		"target_url":  "https://example.org/log",
+		"description": "Build FAILURE after 22m20s",
+		"context":     "cloud-build/my-trigger",
+	} {
+		if got := gh.status[k]; got != want {
+			t.Errorf("Got %v %q; want %q", k, got, want)
+		}
+	}
+}
+
+func TestSetGitHubStatus_App(t *testing.T) {
+	gh := newFakeGitHub(t)
+	defer gh.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal("Failed generating key: ", err)
+	}
+	gh.appKey = &key.PublicKey
+
+	cfg := &Config{
+		githubAPIURL:       gh.URL,
+		githubAppID:        123,
+		githubAppInstallID: 456,
+		githubAppKey:       key,
+		githubOwner:        "my-org",
+		githubContext:      "cloud-build",
+	}
+	build := &cbpb.Build{
+		Status:        cbpb.Build_WORKING,
+		Substitutions: map[string]string{commitSub: "abcdef", repoSub: "my-repo"},
+	}
+	if err := setGitHubStatus(context.Background(), cfg, build); err != nil {
+		t.Fatal("setGitHubStatus failed: ", err)
+	}
+	if want := "token " + gh.appToken; gh.auth != want {
+		t.Errorf("Got Authorization %q; want %q", gh.auth, want)
+	}
+	if want := "/repos/my-org/my-repo/statuses/abcdef"; gh.path != want {
+		t.Errorf("Got path %q; want %q", gh.path, want)
+	}
+	if got, want := gh.status["state"], "pending"; got != want {
+		t.Errorf("Got state %q; want %q", got, want)
+	}
+}
+
+func TestSetGitHubStatus_NotStarted(t *testing.T) {
+	gh := newFakeGitHub(t)
+	defer gh.Close()
+
+	// Builds that are cancelled while queued have a finish time but no start time.
+	cfg := &Config{githubAPIURL: gh.URL, githubToken: "pat", githubContext: "cloud-build"}
+	build := &cbpb.Build{
+		Status:        cbpb.Build_CANCELLED,
+		FinishTime:    makeTimestamp("2021-12-11T20:04:51Z"),
+		Substitutions: map[string]string{commitSub: "abcdef", repoFullNameSub: "my-org/my-repo"},
+	}
+	if err := setGitHubStatus(context.Background(), cfg, build); err != nil {
+		t.Fatal("setGitHubStatus failed: ", err)
+	}
+	if got, want := gh.status["description"], "Build CANCELLED"; got != want {
+		t.Errorf("Got description %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+	tspb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// historyEntry describes a finished build in a badge's history.
+type historyEntry struct {
+	BuildID    string    `json:"buildId"`
+	Status     string    `json:"status"`
+	CreateTime time.Time `json:"createTime"`
+	StartTime  time.Time `json:"startTime"`
+	FinishTime time.Time `json:"finishTime"`
+	Commit     string    `json:"commit,omitempty"`
+	Branch     string    `json:"branch,omitempty"`
+}
+
+// newHistoryEntry returns a historyEntry describing b.
+func newHistoryEntry(b *cbpb.Build) historyEntry {
+	e := historyEntry{
+		BuildID:    b.Id,
+		Status:     b.Status.String(),
+		CreateTime: b.CreateTime.AsTime().UTC(),
+		Commit:     buildSub(b, commitSub, ""),
+		Branch:     buildSub(b, branchSub, ""),
+	}
+	if b.StartTime != nil {
+		e.StartTime = b.StartTime.AsTime().UTC()
+	}
+	if b.FinishTime != nil {
+		e.FinishTime = b.FinishTime.AsTime().UTC()
+	}
+	return e
+}
+
+// duration returns the build's running time, or 0 if it's unknown.
+func (e *historyEntry) duration() time.Duration {
+	if e.StartTime.IsZero() || e.FinishTime.IsZero() {
+		return 0
+	}
+	return e.FinishTime.Sub(e.StartTime)
+}
+
+// build returns a partial build describing e. The trigger and substitutions are copied from tmpl.
+func (e *historyEntry) build(tmpl *cbpb.Build) *cbpb.Build {
+	return &cbpb.Build{
+		Id:             e.BuildID,
+		Status:         cbpb.Build_Status(cbpb.Build_Status_value[e.Status]),
+		ProjectId:      tmpl.ProjectId,
+		BuildTriggerId: tmpl.BuildTriggerId,
+		Substitutions:  tmpl.Substitutions,
+		CreateTime:     tspb.New(e.CreateTime),
+	}
+}
+
+// defaultHistorySize is the default number of builds kept in each history object.
+const defaultHistorySize = 20
+
+// appendHistory atomically adds b to the JSON history object with the supplied name in st
+// and returns the updated history, ordered from oldest to newest creation time.
+// If b was already recorded, its entry is replaced. At most size entries are kept.
+func appendHistory(ctx context.Context, st objectStore, name string,
+	b *cbpb.Build, size int) ([]historyEntry, error) {
+	var entries []historyEntry
+	err := updateObject(ctx, st, name, func(old *object) (*object, error) {
+		entries = nil
+		if old != nil {
+			if err := json.Unmarshal(old.data, &entries); err != nil {
+				return nil, err
+			}
+		}
+		ne := newHistoryEntry(b)
+		found := false
+		for i := range entries {
+			if entries[i].BuildID == ne.BuildID {
+				entries[i], found = ne, true
+			}
+		}
+		if !found {
+			entries = append(entries, ne)
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].CreateTime.Before(entries[j].CreateTime)
+		})
+		if len(entries) > size {
+			entries = entries[len(entries)-size:]
+		}
+
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return nil, err
+		}
+		return &object{data: data, contentType: "application/json", cacheControl: "no-cache"}, nil
+	})
+	return entries, err
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestAppendHistory(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+	const size = 3
+
+	for _, tc := range []struct {
+		build *cbpb.Build
+		want  []string // expected build IDs afterward
+	}{
+		{makeBuild("2", "", cbpb.Build_SUCCESS, "2021-12-02T00:00:00Z"), []string{"2"}},
+		{makeBuild("1", "", cbpb.Build_FAILURE, "2021-12-01T00:00:00Z"), []string{"1", "2"}}, // older
+		{makeBuild("3", "", cbpb.Build_SUCCESS, "2021-12-03T00:00:00Z"), []string{"1", "2", "3"}},
+		{makeBuild("3", "", cbpb.Build_SUCCESS, "2021-12-03T00:00:00Z"), []string{"1", "2", "3"}}, // redelivered
+		{makeBuild("4", "", cbpb.Build_TIMEOUT, "2021-12-04T00:00:00Z"), []string{"2", "3", "4"}},
+	} {
+		entries, err := appendHistory(ctx, st, "hist.json", tc.build, size)
+		if err != nil {
+			t.Fatalf("appendHistory for build %v failed: %v", tc.build.Id, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.BuildID)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("appendHistory for build %v returned %v; want %v", tc.build.Id, got, tc.want)
+		}
+	}
+}
+
+func TestHistoryEntry_Duration(t *testing.T) {
+	b := &cbpb.Build{
+		Id:         "id",
+		Status:     cbpb.Build_SUCCESS,
+		StartTime:  makeTimestamp("2021-12-01T00:00:00Z"),
+		FinishTime: makeTimestamp("2021-12-01T00:03:41Z"),
+	}
+	e := newHistoryEntry(b)
+	if got, want := e.duration(), 3*time.Minute+41*time.Second; got != want {
+		t.Errorf("duration() = %v; want %v", got, want)
+	}
+	b.StartTime = nil // builds that fail before starting don't have start times
+	e = newHistoryEntry(b)
+	if got := e.duration(); got != 0 {
+		t.Errorf("duration() = %v without start time; want 0", got)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// notifier is implemented by types that act on Cloud Build messages,
+// e.g. by sending email or writing badge images.
+type notifier interface {
+	// name returns a short description of the notifier for logging, e.g. "email".
+	name() string
+	// check returns nil if b should be passed to notify and a descriptive error otherwise.
+	// ev describes how b's status compares to the previous build's (see updateState).
+	check(b *cbpb.Build, ev Event) error
+	// notify acts on b. check must be called first.
+	notify(ctx context.Context, b *cbpb.Build, ev Event) error
+}
+
+// notifiers returns the notifiers that should receive builds per cfg.
+func (cfg *Config) notifiers() []notifier {
+	ns := []notifier{
+		&emailNotifier{cfg},
+		&slackNotifier{cfg},
+		&webhookNotifier{cfg},
+		&githubNotifier{cfg},
+		&badgeNotifier{cfg},
+	}
+	for _, r := range cfg.routes {
+		ns = append(ns, r.notifiers(cfg)...)
+	}
+	if cfg.dedupe && cfg.state != nil {
+		for i, n := range ns {
+			ns[i] = &dedupeNotifier{n, cfg.state, cfg.redeliver}
+		}
+	}
+	return ns
+}
+
+// notifierName returns a notifier name for logging that includes cfg.route, if set.
+func (cfg *Config) notifierName(base string) string {
+	if cfg.route == "" {
+		return base
+	}
+	return fmt.Sprintf("%s (route %q)", base, cfg.route)
+}
+
+// runNotifiers passes b and ev to each notifier in ns that accepts them.
+// Errors are logged so that one failing notifier doesn't prevent the others from running.
+// If any notifiers failed with errors that may be temporary, an error listing them is
+// returned after all have run. Permanent errors (see permanentError) are only logged,
+// since retrying the notifiers wouldn't help.
+func runNotifiers(ctx context.Context, ns []notifier, b *cbpb.Build, ev Event) error {
+	var failed []string
+	for _, n := range ns {
+		var perm *permanentError
+		if err := n.check(b, ev); err != nil {
+			log.Printf("Not running %v notifier: %v", n.name(), err)
+		} else if err := n.notify(ctx, b, ev); errors.As(err, &perm) {
+			log.Printf("Failed running %v notifier (not retrying): %v", n.name(), err)
+		} else if err != nil {
+			log.Printf("Failed running %v notifier: %v", n.name(), err)
+			failed = append(failed, n.name())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed running notifier(s): %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// fakeNotifier is a notifier implementation that records the builds that it receives.
+type fakeNotifier struct {
+	checkErr  error    // returned by check
+	notifyErr error    // returned by notify
+	got       []string // IDs of builds passed to notify
+}
+
+func (n *fakeNotifier) name() string                        { return "fake" }
+func (n *fakeNotifier) check(b *cbpb.Build, ev Event) error { return n.checkErr }
+func (n *fakeNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	n.got = append(n.got, b.Id)
+	return n.notifyErr
+}
+
+func TestRunNotifiers(t *testing.T) {
+	accept := &fakeNotifier{}
+	reject := &fakeNotifier{checkErr: errors.New("rejected")}
+	fail := &fakeNotifier{notifyErr: errors.New("failed")}
+	last := &fakeNotifier{}
+
+	if err := runNotifiers(context.Background(), []notifier{accept, reject, fail, last},
+		&cbpb.Build{Id: "build-id"}, EventNone); err == nil {
+		t.Error("runNotifiers didn't return error for failing notifier")
+	}
+
+	want := []string{"build-id"}
+	for _, tc := range []struct {
+		n    *fakeNotifier
+		want []string
+		desc string
+	}{
+		{accept, want, "accepting"},
+		{reject, nil, "rejecting"},
+		{fail, want, "failing"},
+		{last, want, "last"},
+	} {
+		if !reflect.DeepEqual(tc.n.got, tc.want) {
+			t.Errorf("%s notifier got %v; want %v", tc.desc, tc.n.got, tc.want)
+		}
+	}
+
+	// Permanent errors shouldn't be returned, since retrying wouldn't help.
+	perm := &fakeNotifier{notifyErr: &permanentError{errors.New("rejected by server")}}
+	if err := runNotifiers(context.Background(), []notifier{accept, perm},
+		&cbpb.Build{Id: "build-id"}, EventNone); err != nil {
+		t.Error("runNotifiers returned error for permanent failure: ", err)
+	}
+	if want := []string{"build-id"}; !reflect.DeepEqual(perm.got, want) {
+		t.Errorf("Permanently-failing notifier got %v; want %v", perm.got, want)
+	}
+}
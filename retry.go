@@ -0,0 +1,37 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// permanentError wraps an error that shouldn't be retried.
+type permanentError struct{ error }
+
+func (e *permanentError) Unwrap() error { return e.error }
+
+// retry calls fn until it succeeds or has failed retries+1 times.
+// It sleeps for delay after the first failure and doubles the delay after each subsequent failure.
+// Permanent errors (see permanentError) and context cancellation end retries early.
+// The last error returned by fn is returned.
+func retry(ctx context.Context, retries int, delay time.Duration, fn func() error) error {
+	for i := 0; ; i++ {
+		err := fn()
+		var perm *permanentError
+		if err == nil || i >= retries || errors.As(err, &perm) {
+			return err
+		}
+		log.Printf("Retrying in %v after error: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// route describes a set of builds and where notifications about them should be sent.
+// Routes are defined in the file named by CONFIG_FILE.
+type route struct {
+	name   string      // used in logs
+	filter buildFilter // builds matched by the route
+
+	emailRecipients  []*mail.Address   // email recipients, empty to not send email
+	slackWebhookURL  string            // Slack incoming webhook URL, empty to not post to Slack
+	webhookURL       string            // URL to POST JSON build summaries to, empty to not POST
+	webhookSecret    string            // key for HMAC-SHA256 X-Signature header
+	webhookSecretRef string            // secretSource reference for webhookSecret
+	webhookHeaders   map[string]string // additional webhook request headers
+}
+
+// notifiers returns notifiers for r's sinks.
+// Settings not specified by r are taken from cfg.
+func (r *route) notifiers(cfg *Config) []notifier {
+	rcfg := *cfg
+	rcfg.route = r.name
+	rcfg.emailRecipients = r.emailRecipients
+	rcfg.emailFilter = r.filter
+	rcfg.slackWebhookURL = r.slackWebhookURL
+	rcfg.slackFilter = r.filter
+	rcfg.webhookURL = r.webhookURL
+	rcfg.webhookSecret = r.webhookSecret
+	rcfg.webhookHeaders = r.webhookHeaders
+	rcfg.webhookFilter = r.filter
+
+	var ns []notifier
+	if len(r.emailRecipients) > 0 {
+		ns = append(ns, &emailNotifier{&rcfg})
+	}
+	if r.slackWebhookURL != "" {
+		ns = append(ns, &slackNotifier{&rcfg})
+	}
+	if r.webhookURL != "" {
+		ns = append(ns, &webhookNotifier{&rcfg})
+	}
+	return ns
+}
+
+// configFile describes the YAML or JSON file named by CONFIG_FILE, e.g.
+//
+//	routes:
+//	  - name: release
+//	    match:
+//	      triggerNames: [release-*]
+//	      statuses: [FAILURE, TIMEOUT]
+//	    email:
+//	      recipients: [release-team@example.org]
+//	    slack:
+//	      webhookUrl: https://hooks.slack.com/services/...
+type configFile struct {
+	Routes []struct {
+		Name  string `yaml:"name"`
+		Match struct {
+			TriggerIDs   []string `yaml:"triggerIds"`
+			TriggerNames []string `yaml:"triggerNames"`
+			Statuses     []string `yaml:"statuses"`
+			Events       []string `yaml:"events"`
+			Branches     []string `yaml:"branches"`
+			Repos        []string `yaml:"repos"`
+			Tags         []string `yaml:"tags"`
+
+			ExcludeTriggerIDs   []string `yaml:"excludeTriggerIds"`
+			ExcludeTriggerNames []string `yaml:"excludeTriggerNames"`
+			ExcludeBranches     []string `yaml:"excludeBranches"`
+			ExcludeRepos        []string `yaml:"excludeRepos"`
+			ExcludeTags         []string `yaml:"excludeTags"`
+
+			// Keyed by substitution name, e.g. "_DEPLOY_ENV".
+			Substitutions        map[string][]string `yaml:"substitutions"`
+			ExcludeSubstitutions map[string][]string `yaml:"excludeSubstitutions"`
+		} `yaml:"match"`
+		Email struct {
+			Recipients []string `yaml:"recipients"`
+		} `yaml:"email"`
+		Slack struct {
+			WebhookURL string `yaml:"webhookUrl"`
+		} `yaml:"slack"`
+		Webhook struct {
+			URL       string            `yaml:"url"`
+			Secret    string            `yaml:"secret"`
+			SecretRef string            `yaml:"secretRef"` // e.g. "projects/p/secrets/s/versions/1"
+			Headers   map[string]string `yaml:"headers"`
+		} `yaml:"webhook"`
+	} `yaml:"routes"`
+}
+
+// readConfigFile reads the file at p, which may be a local path or a
+// "gs://bucket/object" Cloud Storage URL.
+func readConfigFile(ctx context.Context, p string) ([]byte, error) {
+	if !strings.HasPrefix(p, "gs://") {
+		return ioutil.ReadFile(p)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(p, "gs://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("bad Cloud Storage URL %q", p)
+	}
+	obj, err := (&gcsStore{bucket: parts[0]}).read(ctx, parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return obj.data, nil
+}
+
+// parseRoutes parses routes from data, which contains a configFile as YAML or JSON.
+func parseRoutes(data []byte) ([]*route, error) {
+	var cf configFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cf); err != nil {
+		return nil, err
+	}
+
+	set := func(vals []string) map[string]struct{} {
+		if len(vals) == 0 {
+			return nil
+		}
+		m := make(map[string]struct{}, len(vals))
+		for _, v := range vals {
+			m[v] = struct{}{}
+		}
+		return m
+	}
+
+	subs := func(vals map[string][]string) map[string]map[string]struct{} {
+		if len(vals) == 0 {
+			return nil
+		}
+		m := make(map[string]map[string]struct{}, len(vals))
+		for name, globs := range vals {
+			m[name] = set(globs)
+		}
+		return m
+	}
+
+	routes := make([]*route, len(cf.Routes))
+	for i, cr := range cf.Routes {
+		r := &route{
+			name: cr.Name,
+			filter: buildFilter{
+				triggerIDs:   set(cr.Match.TriggerIDs),
+				triggerNames: set(cr.Match.TriggerNames),
+				statuses:     set(cr.Match.Statuses),
+				events:       set(cr.Match.Events),
+				branches:     set(cr.Match.Branches),
+				repos:        set(cr.Match.Repos),
+				tags:         set(cr.Match.Tags),
+
+				excludeTriggerIDs:    set(cr.Match.ExcludeTriggerIDs),
+				excludeTriggerNames:  set(cr.Match.ExcludeTriggerNames),
+				excludeBranches:      set(cr.Match.ExcludeBranches),
+				excludeRepos:         set(cr.Match.ExcludeRepos),
+				excludeTags:          set(cr.Match.ExcludeTags),
+				substitutions:        subs(cr.Match.Substitutions),
+				excludeSubstitutions: subs(cr.Match.ExcludeSubstitutions),
+			},
+			slackWebhookURL:  cr.Slack.WebhookURL,
+			webhookURL:       cr.Webhook.URL,
+			webhookSecret:    cr.Webhook.Secret,
+			webhookSecretRef: cr.Webhook.SecretRef,
+			webhookHeaders:   cr.Webhook.Headers,
+		}
+		if r.name == "" {
+			r.name = fmt.Sprint(i)
+		}
+		r.filter.prefix = fmt.Sprintf("route %q ", r.name)
+		r.filter.splitNegated()
+		if len(r.filter.statuses) == 0 && len(r.filter.events) == 0 {
+			r.filter.statuses = set(listRegexp.Split(defaultFilterStatuses, -1))
+		}
+		if err := r.filter.validate(); err != nil {
+			return nil, err
+		}
+
+		if len(cr.Email.Recipients) > 0 {
+			var err error
+			if r.emailRecipients, err = mail.ParseAddressList(
+				strings.Join(cr.Email.Recipients, ",")); err != nil {
+				return nil, fmt.Errorf("bad recipients in route %q: %v", r.name, err)
+			}
+		}
+		if r.webhookSecret != "" && r.webhookSecretRef != "" {
+			return nil, fmt.Errorf("route %q has both webhook secret and secretRef", r.name)
+		}
+		if len(r.emailRecipients) == 0 && r.slackWebhookURL == "" && r.webhookURL == "" {
+			return nil, fmt.Errorf("route %q has no sinks", r.name)
+		}
+		routes[i] = r
+	}
+	return routes, nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const testConfigYAML = `
+routes:
+  - name: release
+    match:
+      triggerNames: [release-*]
+      branches: [main, release/*]
+      statuses: [FAILURE, TIMEOUT]
+    email:
+      recipients: [release@example.org, "Some User <user@example.org>"]
+    slack:
+      webhookUrl: https://hooks.slack.com/services/abc
+  - name: tagged
+    match:
+      tags: [deploy-*]
+    webhook:
+      url: https://example.org/hook
+      secret: my-secret
+      headers:
+        X-Custom: custom-value
+`
+
+const testConfigJSON = `{
+  "routes": [
+    {
+      "match": {"repos": ["my-*"], "events": ["FIXED"]},
+      "email": {"recipients": ["team@example.org"]}
+    }
+  ]
+}`
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := parseRoutes([]byte(testConfigYAML))
+	if err != nil {
+		t.Fatal("parseRoutes failed: ", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("parseRoutes returned %d route(s); want 2", len(routes))
+	}
+
+	release, tagged := routes[0], routes[1]
+	if got, want := len(release.emailRecipients), 2; got != want {
+		t.Errorf("Release route has %d recipient(s); want %d", got, want)
+	}
+	if got, want := release.slackWebhookURL, "https://hooks.slack.com/services/abc"; got != want {
+		t.Errorf("Release route has Slack URL %q; want %q", got, want)
+	}
+	if got, want := len(release.notifiers(&Config{})), 2; got != want {
+		t.Errorf("Release route has %d notifier(s); want %d", got, want)
+	}
+	if got, want := tagged.webhookHeaders["X-Custom"], "custom-value"; got != want {
+		t.Errorf("Tagged route has X-Custom header %q; want %q", got, want)
+	}
+	if got, want := len(tagged.notifiers(&Config{})), 1; got != want {
+		t.Errorf("Tagged route has %d notifier(s); want %d", got, want)
+	}
+
+	for _, tc := range []struct {
+		r               *route
+		status          cbpb.Build_Status
+		trigger, branch string
+		tags            []string
+		want            bool // true for nil, false for error
+		desc            string
+	}{
+		{release, cbpb.Build_FAILURE, "release-1", "main", nil, true, "release on main"},
+		{release, cbpb.Build_FAILURE, "release-1", "release/1.0", nil, true, "release on branch"},
+		{release, cbpb.Build_FAILURE, "release-1", "dev", nil, false, "release on dev"},
+		{release, cbpb.Build_SUCCESS, "release-1", "main", nil, false, "release success"},
+		{release, cbpb.Build_FAILURE, "test", "main", nil, false, "wrong trigger"},
+		{tagged, cbpb.Build_FAILURE, "test", "main", []string{"foo", "deploy-prod"}, true, "tag"},
+		{tagged, cbpb.Build_FAILURE, "test", "main", []string{"foo"}, false, "wrong tag"},
+		{tagged, cbpb.Build_SUCCESS, "test", "main", []string{"deploy-prod"}, false, "default statuses"},
+	} {
+		b := makeBuild("", "", tc.status, "", triggerNameSub, tc.trigger, branchSub, tc.branch)
+		b.Tags = tc.tags
+		if err := tc.r.filter.check(b, EventNone); err == nil && !tc.want {
+			t.Errorf("%s: check returned nil; want an error", tc.desc)
+		} else if err != nil && tc.want {
+			t.Errorf("%s: check returned %q; want nil", tc.desc, err)
+		}
+	}
+}
+
+func TestParseRoutes_JSON(t *testing.T) {
+	routes, err := parseRoutes([]byte(testConfigJSON))
+	if err != nil {
+		t.Fatal("parseRoutes failed: ", err)
+	}
+	if len(routes) != 1 {
+		t.Fatalf("parseRoutes returned %d route(s); want 1", len(routes))
+	}
+	r := routes[0]
+	b := &cbpb.Build{Status: cbpb.Build_SUCCESS, Substitutions: map[string]string{repoSub: "my-repo"}}
+	if err := r.filter.check(b, EventFixed); err != nil {
+		t.Errorf("check returned %q for fixed build; want nil", err)
+	}
+	if err := r.filter.check(b, EventStillPassing); err == nil {
+		t.Error("check returned nil for passing build; want an error")
+	}
+}
+
+func TestParseRoutes_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		data, desc string
+	}{
+		{"routes:\n  - email: {recipients: [a@example.org]}\n    bogus: true\n", "unknown field"},
+		{"routes:\n  - match: {statuses: [BOGUS]}\n    email: {recipients: [a@example.org]}\n", "bad status"},
+		{"routes:\n  - email: {recipients: [not-an-address]}\n", "bad recipient"},
+		{"routes:\n  - match: {statuses: [FAILURE]}\n", "no sinks"},
+		{"routes:\n  - webhook: {url: https://example.org, secret: foo, secretRef: env:FOO}\n", "both secrets"},
+	} {
+		if _, err := parseRoutes([]byte(tc.data)); err == nil {
+			t.Errorf("%s: parseRoutes unexpectedly succeeded", tc.desc)
+		}
+	}
+}
+
+func TestLoadConfig_ConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-build-watcher-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(p, []byte(testConfigYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setEnv([]string{
+		"CONFIG_FILE=" + p,
+		"EMAIL_HOSTNAME=mail.example.org",
+		"EMAIL_FROM=sender@example.org",
+		"EMAIL_RECIPIENTS=default@example.org",
+	})()
+	cfg, err := loadConfig(context.Background())
+	if err != nil {
+		t.Fatal("loadConfig failed: ", err)
+	}
+	if len(cfg.routes) != 2 {
+		t.Fatalf("Got %d route(s); want 2", len(cfg.routes))
+	}
+
+	// The environment-based notifiers should still be present,
+	// followed by the route-based notifiers.
+	var names []string
+	for _, n := range cfg.notifiers() {
+		names = append(names, n.name())
+	}
+	const want = `email,Slack,webhook,GitHub,badge,` +
+		`email (route "release"),Slack (route "release"),webhook (route "tagged")`
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("Got notifiers %q; want %q", got, want)
+	}
+}
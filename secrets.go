@@ -0,0 +1,146 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2/google"
+)
+
+// secretSource resolves references to secret values, e.g. passwords.
+type secretSource interface {
+	// secret returns the value referenced by ref.
+	secret(ctx context.Context, ref string) (string, error)
+}
+
+// configSecrets is used by loadConfig to resolve *_SECRET variables.
+// It is a variable so it can be replaced by tests.
+var configSecrets secretSource = &defaultSecretSource{}
+
+// defaultSecretSource is a secretSource that dispatches references based on their prefixes.
+// References starting with "projects/" are resolved by secretManagerSource, while
+// "env:" and "file:" references are resolved by localSecretSource.
+//
+// Secret Manager values are cached for the life of the process, since loadConfig is
+// called for every Pub/Sub message. Updated "latest" versions are picked up when
+// new function instances are started.
+type defaultSecretSource struct {
+	sm    secretManagerSource
+	local localSecretSource
+
+	mu    sync.Mutex        // protects cache
+	cache map[string]string // Secret Manager values keyed by ref
+}
+
+func (src *defaultSecretSource) secret(ctx context.Context, ref string) (string, error) {
+	if !strings.HasPrefix(ref, "projects/") {
+		return src.local.secret(ctx, ref)
+	}
+
+	src.mu.Lock()
+	v, ok := src.cache[ref]
+	src.mu.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	v, err := src.sm.secret(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	src.mu.Lock()
+	if src.cache == nil {
+		src.cache = make(map[string]string)
+	}
+	src.cache[ref] = v
+	src.mu.Unlock()
+	return v, nil
+}
+
+// secretManagerSource is a secretSource that reads secret versions from Secret Manager.
+// References should take the form "projects/<project>/secrets/<secret>/versions/<version>".
+type secretManagerSource struct {
+	endpoint string       // API base URL, defaults to "https://secretmanager.googleapis.com"
+	client   *http.Client // authenticated client, defaults to Application Default Credentials
+
+	mu sync.Mutex // protects client
+}
+
+func (src *secretManagerSource) secret(ctx context.Context, ref string) (string, error) {
+	src.mu.Lock()
+	if src.client == nil {
+		var err error
+		if src.client, err = google.DefaultClient(ctx,
+			"https://www.googleapis.com/auth/cloud-platform"); err != nil {
+			src.mu.Unlock()
+			return "", err
+		}
+	}
+	client := src.client
+	src.mu.Unlock()
+
+	endpoint := src.endpoint
+	if endpoint == "" {
+		endpoint = "https://secretmanager.googleapis.com"
+	}
+	// See https://cloud.google.com/secret-manager/docs/reference/rest/v1/projects.secrets.versions/access.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/v1/"+ref+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("got %v: %q", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var ver struct {
+		Payload struct {
+			Data string `json:"data"` // base64-encoded
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ver); err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(ver.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// localSecretSource is a secretSource that reads secrets from environment variables
+// (for "env:NAME" references) or files (for "file:PATH" references).
+// It is intended for tests and local runs.
+type localSecretSource struct{}
+
+func (localSecretSource) secret(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		n := ref[len("env:"):]
+		v, ok := os.LookupEnv(n)
+		if !ok {
+			return "", fmt.Errorf("%v not set", n)
+		}
+		return v, nil
+	case strings.HasPrefix(ref, "file:"):
+		b, err := ioutil.ReadFile(ref[len("file:"):])
+		return strings.TrimRight(string(b), "\r\n"), err
+	default:
+		return "", fmt.Errorf("unsupported secret reference %q", ref)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretManagerSource(t *testing.T) {
+	const ref = "projects/my-project/secrets/my-secret/versions/latest"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/"+ref+":access" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprintf(w, `{"name":%q,"payload":{"data":%q}}`,
+			ref, base64.StdEncoding.EncodeToString([]byte("my-password\n")))
+	}))
+	defer srv.Close()
+
+	src := &secretManagerSource{endpoint: srv.URL, client: srv.Client()}
+	if got, err := src.secret(context.Background(), ref); err != nil {
+		t.Error("secret failed: ", err)
+	} else if want := "my-password"; got != want {
+		t.Errorf("secret(%q) = %q; want %q", ref, got, want)
+	}
+	if _, err := src.secret(context.Background(), "projects/p/secrets/bogus/versions/1"); err == nil {
+		t.Error("secret unexpectedly succeeded for missing secret")
+	}
+}
+
+func TestDefaultSecretSource_Cache(t *testing.T) {
+	const ref = "projects/my-project/secrets/my-secret/versions/latest"
+	var reqs int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqs++
+		fmt.Fprintf(w, `{"name":%q,"payload":{"data":%q}}`,
+			ref, base64.StdEncoding.EncodeToString([]byte("my-password")))
+	}))
+	defer srv.Close()
+
+	src := &defaultSecretSource{sm: secretManagerSource{endpoint: srv.URL, client: srv.Client()}}
+	for i := 0; i < 3; i++ {
+		if got, err := src.secret(context.Background(), ref); err != nil {
+			t.Error("secret failed: ", err)
+		} else if want := "my-password"; got != want {
+			t.Errorf("secret(%q) = %q; want %q", ref, got, want)
+		}
+	}
+	if reqs != 1 {
+		t.Errorf("Server got %d request(s); want 1", reqs)
+	}
+}
+
+// fakeSecretSource is a secretSource that returns values from a map.
+type fakeSecretSource map[string]string
+
+func (src fakeSecretSource) secret(ctx context.Context, ref string) (string, error) {
+	if v, ok := src[ref]; ok {
+		return v, nil
+	}
+	return "", fmt.Errorf("no secret %q", ref)
+}
+
+func TestLocalSecretSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-build-watcher-test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(p, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setEnv([]string{"TEST_SECRET=env-secret"})()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		ref, want string // want is empty for error
+	}{
+		{"env:TEST_SECRET", "env-secret"},
+		{"env:MISSING_TEST_SECRET", ""},
+		{"file:" + p, "file-secret"},
+		{"file:" + filepath.Join(dir, "missing"), ""},
+		{"bogus:foo", ""},
+	} {
+		if got, err := (localSecretSource{}).secret(ctx, tc.ref); err != nil && tc.want != "" {
+			t.Errorf("secret(%q) failed: %v", tc.ref, err)
+		} else if err == nil && got != tc.want {
+			t.Errorf("secret(%q) = %q; want %q", tc.ref, got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfig_Secrets(t *testing.T) {
+	const (
+		emailRef   = "projects/my-project/secrets/email/versions/1"
+		webhookRef = "projects/my-project/secrets/webhook/versions/latest"
+	)
+	origSecrets := configSecrets
+	configSecrets = fakeSecretSource{emailRef: "email-pass", webhookRef: "webhook-key"}
+	defer func() { configSecrets = origSecrets }()
+
+	defer setEnv([]string{
+		"EMAIL_PASSWORD_SECRET=" + emailRef,
+		"WEBHOOK_SECRET_SECRET=" + webhookRef,
+	})()
+	cfg, err := loadConfig(context.Background())
+	if err != nil {
+		t.Fatal("loadConfig failed: ", err)
+	}
+	if want := "email-pass"; cfg.emailPassword != want {
+		t.Errorf("Got email password %q; want %q", cfg.emailPassword, want)
+	}
+	if want := "webhook-key"; cfg.webhookSecret != want {
+		t.Errorf("Got webhook secret %q; want %q", cfg.webhookSecret, want)
+	}
+}
+
+func TestLoadConfig_BadSecrets(t *testing.T) {
+	for _, env := range [][]string{
+		{"EMAIL_PASSWORD_SECRET=env:MISSING_TEST_SECRET"},
+		{"EMAIL_PASSWORD=pass", "EMAIL_PASSWORD_SECRET=env:HOME"},
+	} {
+		func() {
+			defer setEnv(env)()
+			if _, err := loadConfig(context.Background()); err == nil {
+				t.Errorf("loadConfig unexpectedly succeeded with %q", env)
+			}
+		}()
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// slackNotifier implements notifier by posting messages to a Slack incoming webhook.
+type slackNotifier struct{ cfg *Config }
+
+func (n *slackNotifier) name() string                        { return n.cfg.notifierName("Slack") }
+func (n *slackNotifier) check(b *cbpb.Build, ev Event) error { return n.cfg.checkSlack(b, ev) }
+func (n *slackNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	return postSlack(ctx, n.cfg.slackWebhookURL, b, ev)
+}
+
+// slackTimeout is the timeout for posting a message to Slack.
+const slackTimeout = 10 * time.Second
+
+// postSlack posts a message describing build and ev to the Slack incoming webhook at url.
+// cfg.checkSlack must be called first to check that a message should actually be posted.
+func postSlack(ctx context.Context, url string, build *cbpb.Build, ev Event) error {
+	body, err := json.Marshal(buildSlackMessage(build, ev))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	log.Print("Posting Slack message")
+	client := http.Client{Timeout: slackTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("got %v: %q", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// slackMessage is a Slack message payload.
+// See https://api.slack.com/messaging/webhooks and https://api.slack.com/block-kit.
+type slackMessage struct {
+	Text   string       `json:"text"` // fallback for notifications
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// slackBlock is a Block Kit layout block.
+type slackBlock struct {
+	Type   string       `json:"type"` // e.g. "section" or "context"
+	Text   *slackText   `json:"text,omitempty"`
+	Fields []*slackText `json:"fields,omitempty"`
+}
+
+// slackText is a Block Kit text composition object.
+type slackText struct {
+	Type string `json:"type"` // "mrkdwn" or "plain_text"
+	Text string `json:"text"`
+}
+
+// buildSlackMessage returns a Slack message describing build and ev.
+func buildSlackMessage(build *cbpb.Build, ev Event) *slackMessage {
+	d := newBuildData(build, time.UTC)
+	mrkdwn := func(s string) *slackText { return &slackText{"mrkdwn", s} }
+
+	var fields []*slackText
+	addField := func(name, val string) {
+		if val != "" {
+			fields = append(fields, mrkdwn(fmt.Sprintf("*%s*\n%s", name, val)))
+		}
+	}
+	if d.TriggerID != "" {
+		name := d.TriggerName
+		if name == "" {
+			name = d.TriggerID
+		}
+		addField("Trigger", fmt.Sprintf("<%s|%s>", d.TriggerURL, slackEscape(name)))
+	}
+	addField("Status", d.Status)
+	addField("Repo", slackEscape(d.Repo))
+	addField("Commit", slackEscape(d.Commit))
+	addField("Branch", slackEscape(d.Branch))
+	addField("Duration", d.Duration)
+	if d.LogURL != "" {
+		addField("Log", fmt.Sprintf("<%s|View log>", d.LogURL))
+	}
+
+	summary := buildSummary(build, ev)
+	return &slackMessage{
+		Text: summary,
+		Blocks: []slackBlock{
+			{Type: "section", Text: mrkdwn("*" + slackEscape(summary) + "*")},
+			{Type: "section", Fields: fields}, // Slack permits up to 10 fields
+		},
+	}
+}
+
+// slackEscape escapes the characters in s that have special meaning in Slack's mrkdwn format.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestPostSlack(t *testing.T) {
+	var got *slackMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if ct := req.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Got Content-Type %q; want %q", ct, "application/json")
+		}
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error("Failed reading request: ", err)
+		}
+		got = new(slackMessage)
+		if err := json.Unmarshal(b, got); err != nil {
+			t.Errorf("Failed unmarshaling %q: %v", b, err)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	build := &cbpb.Build{
+		Id:             "1234-5678",
+		ProjectId:      "my-project",
+		BuildTriggerId: "trigger-id",
+		Status:         cbpb.Build_FAILURE,
+		LogUrl:         "https://example.org/log",
+		StartTime:      makeTimestamp("2021-12-11T19:42:31Z"),
+		FinishTime:     makeTimestamp("2021-12-11T20:04:51Z"),
+		Substitutions: map[string]string{
+			branchSub:      "my-branch",
+			commitSub:      "my-commit",
+			repoSub:        "my-repo",
+			triggerNameSub: "<my-trigger>",
+		},
+	}
+	if err := postSlack(context.Background(), srv.URL, build, EventNone); err != nil {
+		t.Fatal("postSlack failed: ", err)
+	}
+
+	mrkdwn := func(s string) *slackText { return &slackText{"mrkdwn", s} }
+	want := &slackMessage{
+		Text: "[my-project] <my-trigger> FAILURE (build 1234)",
+		Blocks: []slackBlock{
+			{Type: "section", Text: mrkdwn("*[my-project] &lt;my-trigger&gt; FAILURE (build 1234)*")},
+			{Type: "section", Fields: []*slackText{
+				mrkdwn("*Trigger*\n<https://console.cloud.google.com/cloud-build/triggers/edit/" +
+					"trigger-id|&lt;my-trigger&gt;>"),
+				mrkdwn("*Status*\nFAILURE"),
+				mrkdwn("*Repo*\nmy-repo"),
+				mrkdwn("*Commit*\nmy-commit"),
+				mrkdwn("*Branch*\nmy-branch"),
+				mrkdwn("*Duration*\n22m20s"),
+				mrkdwn("*Log*\n<https://example.org/log|View log>"),
+			}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		gotJSON, _ := json.MarshalIndent(got, "", "  ")
+		wantJSON, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("Got message:\n%s\nWant:\n%s", gotJSON, wantJSON)
+	}
+}
+
+func TestPostSlack_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	build := &cbpb.Build{Status: cbpb.Build_FAILURE}
+	if err := postSlack(context.Background(), srv.URL, build, EventNone); err == nil {
+		t.Error("postSlack unexpectedly succeeded for bad response")
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// Values for EMAIL_TLS_MODE.
+	tlsModeStartTLS = "starttls" // use STARTTLS if the server supports it
+	tlsModeRequired = "required" // require STARTTLS
+	tlsModeImplicit = "implicit" // connect using TLS, e.g. SMTPS on port 465
+	tlsModeNone     = "none"     // never use TLS
+
+	// Values for EMAIL_AUTH.
+	authPlain   = "plain"
+	authLogin   = "login"
+	authCRAMMD5 = "cram-md5"
+	authXOAUTH2 = "xoauth2"
+)
+
+// defaultOAuthTokenURL is the default OAuth 2.0 token endpoint for XOAUTH2.
+const defaultOAuthTokenURL = "https://oauth2.googleapis.com/token"
+
+// gmailScope is the default OAuth 2.0 scope used with service account keys.
+const gmailScope = "https://mail.google.com/"
+
+// smtpDialTimeout is the timeout for connecting to the SMTP server.
+const smtpDialTimeout = 30 * time.Second
+
+// smtpTimeout is the timeout for the entire SMTP session if ctx doesn't have a deadline.
+const smtpTimeout = 2 * smtpDialTimeout
+
+// sendMail sends msg from from to rcpts using the SMTP server described by cfg.
+// Unlike smtp.SendMail, it supports implicit TLS and configurable STARTTLS and auth.
+func sendMail(ctx context.Context, cfg *Config, from string, rcpts []string, msg []byte) error {
+	addr := net.JoinHostPort(cfg.emailHostname, strconv.Itoa(cfg.emailPort))
+	tlsCfg := &tls.Config{ServerName: cfg.emailHostname, RootCAs: cfg.emailTLSRootCAs}
+	if cfg.emailTLSServerName != "" {
+		tlsCfg.ServerName = cfg.emailTLSServerName
+	}
+
+	// An empty mode (e.g. in a Config not created by loadConfig) gets the default behavior.
+	mode := cfg.emailTLSMode
+	if mode == "" {
+		mode = tlsModeStartTLS
+	}
+
+	dialer := net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	var err error
+	if mode == tlsModeImplicit {
+		conn, err = (&tls.Dialer{NetDialer: &dialer, Config: tlsCfg}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	// net/smtp doesn't take a context, so use a deadline to avoid hanging forever
+	// if the server stops responding (or e.g. is waiting for a TLS handshake).
+	dl, ok := ctx.Deadline()
+	if !ok {
+		dl = time.Now().Add(smtpTimeout)
+	}
+	conn.SetDeadline(dl)
+
+	c, err := smtp.NewClient(conn, cfg.emailHostname)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if mode == tlsModeStartTLS || mode == tlsModeRequired {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(tlsCfg); err != nil {
+				return fmt.Errorf("STARTTLS: %w", err)
+			}
+		} else if mode == tlsModeRequired {
+			return errors.New("server doesn't support STARTTLS")
+		}
+	}
+
+	auth, err := cfg.emailSMTPAuth(ctx)
+	if err != nil {
+		return err
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range rcpts {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// The message was accepted, so don't report an error (and possibly
+	// cause the message to be sent again) if the server drops the connection.
+	if err := c.Quit(); err != nil {
+		log.Print("Failed closing SMTP session: ", err)
+	}
+	return nil
+}
+
+// isTemporarySMTPError returns true if err, returned by sendMail, describes a condition
+// that may go away if the message is sent again later, e.g. a 4xx reply code from the
+// server or a network timeout.
+func isTemporarySMTPError(err error) bool {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code >= 400 && tpErr.Code < 500
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// emailSMTPAuth returns the smtp.Auth to use per cfg, or nil if no auth should be performed.
+// For XOAUTH2, an access token is obtained from the OAuth 2.0 token endpoint.
+func (cfg *Config) emailSMTPAuth(ctx context.Context) (smtp.Auth, error) {
+	if cfg.emailUsername == "" {
+		return nil, nil
+	}
+	switch cfg.emailAuth {
+	case authLogin:
+		return &loginAuth{cfg.emailUsername, cfg.emailPassword, cfg.emailHostname}, nil
+	case authCRAMMD5:
+		return smtp.CRAMMD5Auth(cfg.emailUsername, cfg.emailPassword), nil
+	case authXOAUTH2:
+		ts, err := cfg.emailTokenSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tok, err := ts.Token()
+		if err != nil {
+			return nil, fmt.Errorf("getting OAuth token: %v", err)
+		}
+		return &xoauth2Auth{cfg.emailUsername, tok.AccessToken, cfg.emailHostname}, nil
+	default:
+		return smtp.PlainAuth("", cfg.emailUsername, cfg.emailPassword, cfg.emailHostname), nil
+	}
+}
+
+// emailTokenSource returns a source of OAuth 2.0 access tokens for XOAUTH2.
+// If a service account key is configured, it is used to impersonate cfg.emailUsername
+// via domain-wide delegation. Otherwise, the refresh token and client credentials are used.
+func (cfg *Config) emailTokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: smtpDialTimeout})
+	if len(cfg.emailOAuthKey) > 0 {
+		scopes := cfg.emailOAuthScopes
+		if len(scopes) == 0 {
+			scopes = []string{gmailScope}
+		}
+		jc, err := google.JWTConfigFromJSON(cfg.emailOAuthKey, scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("bad service account key: %v", err)
+		}
+		jc.Subject = cfg.emailUsername
+		if cfg.emailOAuthTokenURL != "" {
+			jc.TokenURL = cfg.emailOAuthTokenURL
+		}
+		return jc.TokenSource(ctx), nil
+	}
+
+	oc := oauth2.Config{
+		ClientID:     cfg.emailOAuthClientID,
+		ClientSecret: cfg.emailOAuthClientSecret,
+		Endpoint:     oauth2.Endpoint{TokenURL: cfg.emailOAuthTokenURL},
+		Scopes:       cfg.emailOAuthScopes,
+	}
+	if oc.Endpoint.TokenURL == "" {
+		oc.Endpoint.TokenURL = defaultOAuthTokenURL
+	}
+	return oc.TokenSource(ctx, &oauth2.Token{RefreshToken: cfg.emailOAuthRefreshToken}), nil
+}
+
+// loginAuth implements smtp.Auth for the non-standard but widely-used LOGIN mechanism.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Like smtp.PlainAuth, refuse to send credentials over unencrypted connections.
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.username), nil
+	case "Password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected challenge %q", fromServer)
+	}
+}
+
+// xoauth2Auth implements smtp.Auth for the XOAUTH2 mechanism supported by Gmail and
+// Microsoft 365. See https://developers.google.com/gmail/imap/xoauth2-protocol.
+type xoauth2Auth struct {
+	username, token, host string
+}
+
+func (a *xoauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "XOAUTH2", []byte("user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	// On failure, the server sends a base64-encoded JSON error as a challenge.
+	return nil, fmt.Errorf("server rejected token: %s", fromServer)
+}
+
+// isLocalhost returns true if host refers to the local machine.
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
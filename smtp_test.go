@@ -0,0 +1,524 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// fakeSMTPMessage describes a message received by fakeSMTPServer.
+type fakeSMTPMessage struct {
+	from  string
+	rcpts []string
+	data  string
+	tls   bool   // true if the message was received over TLS
+	mech  string // SASL mechanism used to authenticate, e.g. "PLAIN"
+}
+
+// fakeSMTPServer is a minimal in-process SMTP server for testing sendMail.
+type fakeSMTPServer struct {
+	t        *testing.T
+	ln       net.Listener
+	tlsCfg   *tls.Config    // server TLS config
+	rootCAs  *x509.CertPool // contains the server's certificate
+	implicit bool           // accept connections using TLS
+	startTLS bool           // advertise STARTTLS
+	username string         // expected username
+	password string         // expected password for PLAIN, LOGIN, and CRAM-MD5
+	token    string         // expected access token for XOAUTH2
+
+	dataCode  int // if non-zero, reply code for DATA while dataFails is positive
+	dataFails int // number of remaining DATA commands to reject with dataCode
+
+	mu   sync.Mutex
+	msgs []fakeSMTPMessage
+}
+
+// newFakeSMTPServer starts a new fakeSMTPServer.
+// The returned server should be closed using close.
+func newFakeSMTPServer(t *testing.T, implicit, startTLS bool) *fakeSMTPServer {
+	// Borrow httptest's certificate, which is valid for 127.0.0.1 and example.com.
+	hs := httptest.NewUnstartedServer(nil)
+	hs.StartTLS()
+	tlsCfg := &tls.Config{Certificates: hs.TLS.Certificates}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(hs.Certificate())
+	hs.Close()
+
+	var ln net.Listener
+	var err error
+	if implicit {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsCfg)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal("Failed listening: ", err)
+	}
+
+	srv := &fakeSMTPServer{
+		t:        t,
+		ln:       ln,
+		tlsCfg:   tlsCfg,
+		rootCAs:  rootCAs,
+		implicit: implicit,
+		startTLS: startTLS,
+		username: "user",
+		password: "pass",
+		token:    "access-token",
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handle(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *fakeSMTPServer) close() { srv.ln.Close() }
+
+// config returns a Config for sending mail to srv.
+func (srv *fakeSMTPServer) config(tlsMode, auth string) *Config {
+	_, port, _ := net.SplitHostPort(srv.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return &Config{
+		emailHostname:   "127.0.0.1",
+		emailPort:       p,
+		emailUsername:   srv.username,
+		emailPassword:   srv.password,
+		emailAuth:       auth,
+		emailTLSMode:    tlsMode,
+		emailTLSRootCAs: srv.rootCAs,
+	}
+}
+
+// messages returns the messages received by srv.
+func (srv *fakeSMTPServer) messages() []fakeSMTPMessage {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return append([]fakeSMTPMessage(nil), srv.msgs...)
+}
+
+func (srv *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	isTLS := srv.implicit
+	var msg fakeSMTPMessage
+
+	reply := func(s string) { tc.PrintfLine("%s", s) }
+	readB64 := func() string {
+		line, _ := tc.ReadLine()
+		b, _ := base64.StdEncoding.DecodeString(line)
+		return string(b)
+	}
+	authed := func(ok bool, mech string) {
+		if ok {
+			msg.mech = mech
+			reply("235 Authenticated")
+		} else {
+			reply("535 Bad credentials")
+		}
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			reply("500 Empty command")
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "EHLO", "HELO":
+			exts := []string{"fake"}
+			if srv.startTLS && !isTLS {
+				exts = append(exts, "STARTTLS")
+			}
+			exts = append(exts, "AUTH PLAIN LOGIN CRAM-MD5 XOAUTH2", "8BITMIME")
+			for i, e := range exts {
+				sep := "-"
+				if i == len(exts)-1 {
+					sep = " "
+				}
+				reply("250" + sep + e)
+			}
+		case "STARTTLS":
+			reply("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, srv.tlsCfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tc = textproto.NewConn(conn)
+			isTLS = true
+		case "AUTH":
+			switch mech := strings.ToUpper(fields[1]); mech {
+			case "PLAIN":
+				var resp string
+				if len(fields) > 2 {
+					b, _ := base64.StdEncoding.DecodeString(fields[2])
+					resp = string(b)
+				} else {
+					reply("334 ")
+					resp = readB64()
+				}
+				authed(resp == "\x00"+srv.username+"\x00"+srv.password, mech)
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				user := readB64()
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				pass := readB64()
+				authed(user == srv.username && pass == srv.password, mech)
+			case "CRAM-MD5":
+				const challenge = "<123.456@fake>"
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte(challenge)))
+				mac := hmac.New(md5.New, []byte(srv.password))
+				mac.Write([]byte(challenge))
+				authed(readB64() == srv.username+" "+hex.EncodeToString(mac.Sum(nil)), mech)
+			case "XOAUTH2":
+				var resp string
+				if len(fields) > 2 {
+					b, _ := base64.StdEncoding.DecodeString(fields[2])
+					resp = string(b)
+				}
+				if resp == "user="+srv.username+"\x01auth=Bearer "+srv.token+"\x01\x01" {
+					authed(true, mech)
+				} else {
+					// Send an error challenge like Gmail and wait for the client's response.
+					reply("334 " + base64.StdEncoding.EncodeToString([]byte(`{"status":"401"}`)))
+					tc.ReadLine()
+					authed(false, mech)
+				}
+			default:
+				reply("504 Unsupported mechanism")
+			}
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(strings.ToUpper(fields[1]), "FROM:"), "<>")
+			reply("250 OK")
+		case "RCPT":
+			msg.rcpts = append(msg.rcpts, strings.Trim(strings.TrimPrefix(fields[1], "TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			srv.mu.Lock()
+			fail := srv.dataCode != 0 && srv.dataFails > 0
+			if fail {
+				srv.dataFails--
+			}
+			srv.mu.Unlock()
+			if fail {
+				reply(strconv.Itoa(srv.dataCode) + " Try again later")
+				continue
+			}
+			reply("354 Go ahead")
+			data, err := ioutil.ReadAll(tc.DotReader())
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			msg.tls = isTLS
+			srv.mu.Lock()
+			srv.msgs = append(srv.msgs, msg)
+			srv.mu.Unlock()
+			reply("250 Queued")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Unimplemented")
+		}
+	}
+}
+
+// smtpTestTimeout is used as the deadline for sendMail calls in tests.
+// It's short since the server may be waiting for a TLS handshake that never happens.
+const smtpTestTimeout = 3 * time.Second
+
+func TestSendMail(t *testing.T) {
+	for _, tc := range []struct {
+		implicit bool   // server uses implicit TLS
+		startTLS bool   // server advertises STARTTLS
+		mode     string // EMAIL_TLS_MODE
+		auth     string // EMAIL_AUTH
+		wantTLS  bool
+		wantMech string // empty if sendMail should fail
+	}{
+		{false, true, tlsModeStartTLS, authPlain, true, "PLAIN"},
+		{false, true, "", authPlain, true, "PLAIN"}, // zero-value Config defaults to STARTTLS
+		{false, false, tlsModeStartTLS, authPlain, false, "PLAIN"},
+		{false, true, tlsModeRequired, authLogin, true, "LOGIN"},
+		{false, false, tlsModeRequired, authPlain, false, ""},
+		{true, false, tlsModeImplicit, authCRAMMD5, true, "CRAM-MD5"},
+		{false, true, tlsModeNone, authCRAMMD5, false, "CRAM-MD5"},
+		{true, false, tlsModeStartTLS, authPlain, false, ""}, // plaintext to TLS server
+	} {
+		func() {
+			srv := newFakeSMTPServer(t, tc.implicit, tc.startTLS)
+			defer srv.close()
+
+			desc := tc.mode + "/" + tc.auth
+			cfg := srv.config(tc.mode, tc.auth)
+			ctx, cancel := context.WithTimeout(context.Background(), smtpTestTimeout)
+			defer cancel()
+			err := sendMail(ctx, cfg, "from@example.org",
+				[]string{"to1@example.org", "to2@example.org"}, []byte("Subject: Hi\r\n\r\nBody\r\n"))
+			if tc.wantMech == "" {
+				if err == nil {
+					t.Errorf("%s: sendMail unexpectedly succeeded", desc)
+				}
+				return
+			} else if err != nil {
+				t.Errorf("%s: sendMail failed: %v", desc, err)
+				return
+			}
+
+			msgs := srv.messages()
+			if len(msgs) != 1 {
+				t.Errorf("%s: server got %d message(s); want 1", desc, len(msgs))
+				return
+			}
+			msg := msgs[0]
+			if msg.tls != tc.wantTLS {
+				t.Errorf("%s: server got TLS %v; want %v", desc, msg.tls, tc.wantTLS)
+			}
+			if msg.mech != tc.wantMech {
+				t.Errorf("%s: server got mechanism %q; want %q", desc, msg.mech, tc.wantMech)
+			}
+			if got, want := strings.Join(msg.rcpts, ","), "to1@example.org,to2@example.org"; got != want {
+				t.Errorf("%s: server got recipients %q; want %q", desc, got, want)
+			}
+			if !strings.Contains(msg.data, "Body") {
+				t.Errorf("%s: server got data %q", desc, msg.data)
+			}
+		}()
+	}
+}
+
+func TestSendEmail_Retry(t *testing.T) {
+	origDelay := emailRetryDelay
+	emailRetryDelay = time.Millisecond
+	defer func() { emailRetryDelay = origDelay }()
+
+	for _, tc := range []struct {
+		code, fails int // server's DATA reply code and number of failures
+		retries     int // cfg.emailRetries
+		ok          bool
+	}{
+		{451, 2, 2, true},  // temporary errors are retried
+		{451, 3, 2, false}, // too many temporary errors
+		{554, 1, 2, false}, // permanent errors aren't retried
+	} {
+		func() {
+			srv := newFakeSMTPServer(t, false, true)
+			defer srv.close()
+			srv.dataCode = tc.code
+			srv.dataFails = tc.fails
+
+			cfg := srv.config(tlsModeStartTLS, authPlain)
+			cfg.emailFrom = &mail.Address{Address: "from@example.org"}
+			cfg.emailRecipients = []*mail.Address{{Address: "to@example.org"}}
+			cfg.emailTimeZone = time.UTC
+			cfg.emailRetries = tc.retries
+
+			ctx, cancel := context.WithTimeout(context.Background(), smtpTestTimeout)
+			defer cancel()
+			err := sendEmail(ctx, cfg, &cbpb.Build{Status: cbpb.Build_FAILURE}, EventNone)
+			if tc.ok && err != nil {
+				t.Errorf("sendEmail with %d failure(s) with %d failed: %v", tc.fails, tc.code, err)
+			} else if !tc.ok && err == nil {
+				t.Errorf("sendEmail with %d failure(s) with %d unexpectedly succeeded", tc.fails, tc.code)
+			}
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			if tc.code >= 500 && srv.dataFails != tc.fails-1 {
+				t.Errorf("sendEmail retried after %d", tc.code)
+			}
+		}()
+	}
+}
+
+func TestSendMail_TLSVerification(t *testing.T) {
+	srv := newFakeSMTPServer(t, true, false)
+	defer srv.close()
+	send := func(cfg *Config) error {
+		ctx, cancel := context.WithTimeout(context.Background(), smtpTestTimeout)
+		defer cancel()
+		return sendMail(ctx, cfg, "from@example.org",
+			[]string{"to@example.org"}, []byte("Subject: Hi\r\n\r\nBody\r\n"))
+	}
+
+	// The server's certificate is also valid for example.com.
+	cfg := srv.config(tlsModeImplicit, authPlain)
+	cfg.emailTLSServerName = "example.com"
+	if err := send(cfg); err != nil {
+		t.Error("sendMail with server name override failed: ", err)
+	}
+
+	cfg.emailTLSServerName = "bogus.example.net"
+	if err := send(cfg); err == nil {
+		t.Error("sendMail with bad server name unexpectedly succeeded")
+	}
+
+	// The server's certificate isn't signed by a CA in the system pool.
+	cfg = srv.config(tlsModeImplicit, authPlain)
+	cfg.emailTLSRootCAs = nil
+	if err := send(cfg); err == nil {
+		t.Error("sendMail with system CAs unexpectedly succeeded")
+	}
+}
+
+// newFakeTokenServer starts an HTTP server that implements an OAuth 2.0 token endpoint.
+// Requests are passed to check, which should return an error if they're invalid.
+// Valid requests receive tok as an access token.
+func newFakeTokenServer(t *testing.T, tok string, check func(vals url.Values) error) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := check(req.PostForm); err != nil {
+			t.Error("Bad token request: ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","expires_in":3600}`, tok)
+	}))
+}
+
+func TestSendMail_XOAUTH2_RefreshToken(t *testing.T) {
+	srv := newFakeSMTPServer(t, false, true)
+	defer srv.close()
+
+	for _, tc := range []struct {
+		tok string // access token returned by token endpoint
+		ok  bool   // true if sendMail should succeed
+	}{
+		{srv.token, true},
+		{"bad-token", false},
+	} {
+		ts := newFakeTokenServer(t, tc.tok, func(vals url.Values) error {
+			if got, want := vals.Get("grant_type"), "refresh_token"; got != want {
+				return fmt.Errorf("grant_type %q; want %q", got, want)
+			}
+			if got, want := vals.Get("refresh_token"), "refresh-token"; got != want {
+				return fmt.Errorf("refresh_token %q; want %q", got, want)
+			}
+			return nil
+		})
+		defer ts.Close()
+
+		cfg := srv.config(tlsModeRequired, authXOAUTH2)
+		cfg.emailOAuthClientID = "client-id"
+		cfg.emailOAuthClientSecret = "client-secret"
+		cfg.emailOAuthRefreshToken = "refresh-token"
+		cfg.emailOAuthTokenURL = ts.URL
+
+		ctx, cancel := context.WithTimeout(context.Background(), smtpTestTimeout)
+		defer cancel()
+		err := sendMail(ctx, cfg, "from@example.org", []string{"to@example.org"},
+			[]byte("Subject: Hi\r\n\r\nBody\r\n"))
+		if tc.ok && err != nil {
+			t.Errorf("sendMail with token %q failed: %v", tc.tok, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("sendMail with token %q unexpectedly succeeded", tc.tok)
+		}
+	}
+	if msgs := srv.messages(); len(msgs) != 1 || msgs[0].mech != "XOAUTH2" {
+		t.Errorf("Server got %+v; want one XOAUTH2 message", msgs)
+	}
+}
+
+func TestSendMail_XOAUTH2_ServiceAccount(t *testing.T) {
+	srv := newFakeSMTPServer(t, false, true)
+	defer srv.close()
+
+	ts := newFakeTokenServer(t, srv.token, func(vals url.Values) error {
+		if got, want := vals.Get("grant_type"), "urn:ietf:params:oauth:grant-type:jwt-bearer"; got != want {
+			return fmt.Errorf("grant_type %q; want %q", got, want)
+		}
+		parts := strings.Split(vals.Get("assertion"), ".")
+		if len(parts) != 3 {
+			return fmt.Errorf("bad assertion %q", vals.Get("assertion"))
+		}
+		b, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return err
+		}
+		var claims struct {
+			Sub   string `json:"sub"`
+			Scope string `json:"scope"`
+		}
+		if err := json.Unmarshal(b, &claims); err != nil {
+			return err
+		}
+		if claims.Sub != srv.username || claims.Scope != gmailScope {
+			return fmt.Errorf("got sub %q and scope %q; want %q and %q",
+				claims.Sub, claims.Scope, srv.username, gmailScope)
+		}
+		return nil
+	})
+	defer ts.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyJSON, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "sender@my-project.iam.gserviceaccount.com",
+		"private_key_id": "key-id",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		"token_uri": ts.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := srv.config(tlsModeRequired, authXOAUTH2)
+	cfg.emailOAuthKey = keyJSON
+	ctx, cancel := context.WithTimeout(context.Background(), smtpTestTimeout)
+	defer cancel()
+	if err := sendMail(ctx, cfg, "from@example.org", []string{"to@example.org"},
+		[]byte("Subject: Hi\r\n\r\nBody\r\n")); err != nil {
+		t.Fatal("sendMail failed: ", err)
+	}
+	if msgs := srv.messages(); len(msgs) != 1 || msgs[0].mech != "XOAUTH2" {
+		t.Errorf("Server got %+v; want one XOAUTH2 message", msgs)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+// Event describes how a build's terminal status relates to the previous build's.
+type Event string
+
+const (
+	// EventNone is used for non-terminal builds and when state tracking is disabled.
+	EventNone Event = ""
+	// EventBroken is used for a failed build following a successful one (or no previous build).
+	EventBroken Event = "BROKEN"
+	// EventStillFailing is used for a failed build following another failed build.
+	EventStillFailing Event = "STILL_FAILING"
+	// EventFixed is used for a successful build following a failed build.
+	EventFixed Event = "FIXED"
+	// EventStillPassing is used for a successful build following another successful build
+	// (or no previous build).
+	EventStillPassing Event = "STILL_PASSING"
+)
+
+// validEvents contains all valid non-empty Event values.
+var validEvents = map[Event]struct{}{
+	EventBroken:       {},
+	EventStillFailing: {},
+	EventFixed:        {},
+	EventStillPassing: {},
+}
+
+// failingStatuses contains terminal statuses that are considered to be failures.
+var failingStatuses = map[cbpb.Build_Status]struct{}{
+	cbpb.Build_FAILURE:        {},
+	cbpb.Build_INTERNAL_ERROR: {},
+	cbpb.Build_TIMEOUT:        {},
+}
+
+// buildState is the JSON-marshaled content of state objects.
+type buildState struct {
+	BuildID    string    `json:"buildId"`
+	Status     string    `json:"status"`
+	CreateTime time.Time `json:"createTime"`
+	Event      Event     `json:"event"` // event that was computed for the build
+}
+
+// stateName returns the name of the state object for b per cfg.
+func stateName(cfg *Config, b *cbpb.Build) string {
+	name := url.PathEscape(b.BuildTriggerId)
+	if cfg.statePerBranch {
+		name += "/" + url.PathEscape(buildSub(b, branchSub, ""))
+	}
+	return name + ".json"
+}
+
+// updateState records b's status in cfg.state and returns an Event describing how it
+// compares to the previous build's status. EventNone is returned if state tracking is
+// disabled, b wasn't started by a trigger, b is non-terminal or was cancelled,
+// or b was created before the last-recorded build. If b was already recorded,
+// the previously-returned Event is returned again.
+func updateState(ctx context.Context, cfg *Config, b *cbpb.Build) (Event, error) {
+	if cfg.state == nil || b.BuildTriggerId == "" {
+		return EventNone, nil
+	}
+	var failed bool
+	if _, failed = failingStatuses[b.Status]; !failed && b.Status != cbpb.Build_SUCCESS {
+		return EventNone, nil
+	}
+
+	name := stateName(cfg, b)
+	// Use the creation time rather than the start time for ordering, since builds
+	// that fail before starting (e.g. with INTERNAL_ERROR) have no start time.
+	created := b.CreateTime.AsTime()
+	var ev Event
+	if err := updateObject(ctx, cfg.state, name, func(old *object) (*object, error) {
+		var prevFailed bool
+		ev = EventNone
+		if old != nil {
+			var prev buildState
+			if err := json.Unmarshal(old.data, &prev); err != nil {
+				return nil, err
+			}
+			if prev.BuildID == b.Id {
+				ev = prev.Event // redelivered message
+				return nil, nil
+			} else if created.Before(prev.CreateTime) {
+				return nil, nil // stale message
+			}
+			_, prevFailed = failingStatuses[cbpb.Build_Status(cbpb.Build_Status_value[prev.Status])]
+		}
+
+		switch {
+		case failed && prevFailed:
+			ev = EventStillFailing
+		case failed:
+			ev = EventBroken
+		case prevFailed:
+			ev = EventFixed
+		default:
+			ev = EventStillPassing
+		}
+
+		data, err := json.Marshal(&buildState{b.Id, b.Status.String(), created, ev})
+		if err != nil {
+			return nil, err
+		}
+		return &object{data: data, contentType: "application/json"}, nil
+	}); err != nil {
+		return EventNone, err
+	}
+	if ev != EventNone {
+		log.Printf("Build %v for %v is %v", b.Id, name, ev)
+	}
+	return ev, nil
+}
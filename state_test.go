@@ -0,0 +1,58 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"testing"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestUpdateState(t *testing.T) {
+	ctx := context.Background()
+	cfg := &Config{state: newMemStore()}
+
+	for _, tc := range []struct {
+		id        string
+		status    cbpb.Build_Status
+		created   string
+		branch    string
+		perBranch bool
+		want      Event
+		desc      string
+	}{
+		{"1", cbpb.Build_SUCCESS, "2021-12-01T00:00:00Z", "main", false, EventStillPassing, "first success"},
+		{"2", cbpb.Build_WORKING, "2021-12-02T00:00:00Z", "main", false, EventNone, "working"},
+		{"2", cbpb.Build_FAILURE, "2021-12-02T00:00:00Z", "main", false, EventBroken, "broken"},
+		{"2", cbpb.Build_FAILURE, "2021-12-02T00:00:00Z", "main", false, EventBroken, "redelivered"},
+		{"0", cbpb.Build_SUCCESS, "2021-11-30T00:00:00Z", "main", false, EventNone, "stale"},
+		{"3", cbpb.Build_TIMEOUT, "2021-12-03T00:00:00Z", "main", false, EventStillFailing, "still failing"},
+		{"4", cbpb.Build_CANCELLED, "2021-12-04T00:00:00Z", "main", false, EventNone, "cancelled"},
+		{"5", cbpb.Build_SUCCESS, "2021-12-05T00:00:00Z", "main", false, EventFixed, "fixed"},
+		{"6", cbpb.Build_SUCCESS, "2021-12-06T00:00:00Z", "main", false, EventStillPassing, "still passing"},
+		{"7", cbpb.Build_FAILURE, "2021-12-07T00:00:00Z", "dev", true, EventBroken, "branch broken"},
+		{"8", cbpb.Build_SUCCESS, "2021-12-08T00:00:00Z", "main", true, EventStillPassing, "other branch"},
+		{"9", cbpb.Build_FAILURE, "2021-12-09T00:00:00Z", "dev", true, EventStillFailing, "branch still failing"},
+		// None of these builds have start times, as is the case for builds that fail before starting.
+		{"10", cbpb.Build_INTERNAL_ERROR, "2021-12-10T00:00:00Z", "main", false, EventBroken, "unstarted"},
+	} {
+		cfg.statePerBranch = tc.perBranch
+		b := makeBuild(tc.id, "trigger-id", tc.status, tc.created, branchSub, tc.branch)
+		if got, err := updateState(ctx, cfg, b); err != nil {
+			t.Errorf("%s: updateState failed: %v", tc.desc, err)
+		} else if got != tc.want {
+			t.Errorf("%s: updateState returned %q; want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestUpdateState_Disabled(t *testing.T) {
+	b := &cbpb.Build{Id: "1", BuildTriggerId: "trigger-id", Status: cbpb.Build_FAILURE}
+	if got, err := updateState(context.Background(), &Config{}, b); err != nil {
+		t.Error("updateState failed: ", err)
+	} else if got != EventNone {
+		t.Errorf("updateState returned %q; want %q", got, EventNone)
+	}
+}
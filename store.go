@@ -0,0 +1,298 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// errNotExist is returned by objectStore.read if the requested object doesn't exist.
+	errNotExist = errors.New("object does not exist")
+	// errPrecondition is returned by objectStore.write if the object's generation didn't match.
+	errPrecondition = errors.New("generation precondition failed")
+)
+
+// anyGen can be passed to objectStore.write to write an object unconditionally.
+const anyGen = -1
+
+// object is a blob persisted by an objectStore.
+type object struct {
+	data         []byte
+	contentType  string            // e.g. "application/json"
+	cacheControl string            // e.g. "no-cache"
+	metadata     map[string]string // arbitrary key/value pairs
+	gen          int64             // generation, set by objectStore.read
+}
+
+// objectStore persists objects between invocations of the Cloud Function.
+type objectStore interface {
+	// read returns the object with the supplied name, or errNotExist if it doesn't exist.
+	read(ctx context.Context, name string) (*object, error)
+	// write writes obj under name. If gen is 0, the object must not already exist.
+	// If gen is positive, the existing object's generation must match it.
+	// errPrecondition is returned if these conditions aren't met.
+	// If gen is anyGen, the object is written unconditionally.
+	write(ctx context.Context, name string, obj *object, gen int64) error
+}
+
+// updateObject atomically replaces the object with the supplied name in st
+// with the result of calling fn with its current value (or nil if it doesn't exist).
+// fn may be called multiple times if the object is concurrently modified.
+// If fn returns a nil object, the object is not written.
+func updateObject(ctx context.Context, st objectStore, name string,
+	fn func(old *object) (*object, error)) error {
+	for {
+		old, err := st.read(ctx, name)
+		var gen int64
+		if err == errNotExist {
+			old = nil
+		} else if err != nil {
+			return err
+		} else {
+			gen = old.gen
+		}
+
+		obj, err := fn(old)
+		if err != nil || obj == nil {
+			return err
+		}
+		if err := st.write(ctx, name, obj, gen); err != errPrecondition {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// gcsStore is an objectStore implementation that persists objects in Cloud Storage.
+type gcsStore struct {
+	bucket string // bucket name, e.g. "my-bucket"
+	prefix string // prepended to object names, e.g. "state/"
+
+	mu     sync.Mutex
+	client *storage.Client // lazily initialized
+}
+
+func (st *gcsStore) handle(ctx context.Context, name string) (*storage.ObjectHandle, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.client == nil {
+		var err error
+		if st.client, err = storage.NewClient(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return st.client.Bucket(st.bucket).Object(st.prefix + name), nil
+}
+
+func (st *gcsStore) read(ctx context.Context, name string) (*object, error) {
+	h, err := st.handle(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		attrs, err := h.Attrs(ctx)
+		if err == storage.ErrObjectNotExist {
+			return nil, errNotExist
+		} else if err != nil {
+			return nil, err
+		}
+		// Read the generation that we got attributes for. If it was replaced in the meantime,
+		// start over.
+		r, err := h.Generation(attrs.Generation).NewReader(ctx)
+		if err == storage.ErrObjectNotExist {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		return &object{
+			data:         data,
+			contentType:  attrs.ContentType,
+			cacheControl: attrs.CacheControl,
+			metadata:     attrs.Metadata,
+			gen:          attrs.Generation,
+		}, nil
+	}
+}
+
+func (st *gcsStore) write(ctx context.Context, name string, obj *object, gen int64) error {
+	h, err := st.handle(ctx, name)
+	if err != nil {
+		return err
+	}
+	switch {
+	case gen == 0:
+		h = h.If(storage.Conditions{DoesNotExist: true})
+	case gen > 0:
+		h = h.If(storage.Conditions{GenerationMatch: gen})
+	}
+	w := h.NewWriter(ctx)
+	w.ContentType = obj.contentType
+	w.CacheControl = obj.cacheControl
+	w.Metadata = obj.metadata
+	if _, err := w.Write(obj.data); err != nil {
+		w.Close()
+		return err
+	}
+	err = w.Close()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 412 {
+		return errPrecondition
+	}
+	return err
+}
+
+// dirStore is an objectStore implementation that persists objects as files in a local directory.
+// Each object's attributes are stored in an additional file with a ".attrs" suffix.
+// Generation preconditions are only enforced within a single process.
+type dirStore struct {
+	dir string // directory containing objects
+
+	mu sync.Mutex
+}
+
+// dirAttrs is the JSON-marshaled content of dirStore's attribute files.
+type dirAttrs struct {
+	ContentType  string            `json:"contentType"`
+	CacheControl string            `json:"cacheControl"`
+	Metadata     map[string]string `json:"metadata"`
+	Gen          int64             `json:"gen"`
+}
+
+func (st *dirStore) path(name string) string { return filepath.Join(st.dir, filepath.FromSlash(name)) }
+
+func (st *dirStore) read(ctx context.Context, name string) (*object, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.readLocked(name)
+}
+
+func (st *dirStore) readLocked(name string) (*object, error) {
+	p := st.path(name)
+	ab, err := ioutil.ReadFile(p + ".attrs")
+	if os.IsNotExist(err) {
+		return nil, errNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	var attrs dirAttrs
+	if err := json.Unmarshal(ab, &attrs); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return &object{
+		data:         data,
+		contentType:  attrs.ContentType,
+		cacheControl: attrs.CacheControl,
+		metadata:     attrs.Metadata,
+		gen:          attrs.Gen,
+	}, nil
+}
+
+func (st *dirStore) write(ctx context.Context, name string, obj *object, gen int64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	var cur int64
+	if old, err := st.readLocked(name); err == nil {
+		cur = old.gen
+	} else if err != errNotExist {
+		return err
+	}
+	if gen != anyGen && gen != cur {
+		return errPrecondition
+	}
+
+	p := st.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	ab, err := json.Marshal(&dirAttrs{obj.contentType, obj.cacheControl, obj.metadata, cur + 1})
+	if err != nil {
+		return err
+	}
+	// Write the attributes last since their presence indicates that the object exists.
+	if err := writeFileAtomic(p, obj.data); err != nil {
+		return err
+	}
+	return writeFileAtomic(p+".attrs", ab)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to p.
+func writeFileAtomic(p string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// memStore is an objectStore implementation that keeps objects in memory.
+// It is used by tests.
+type memStore struct {
+	mu      sync.Mutex
+	objects map[string]*object
+	lastGen int64
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string]*object)}
+}
+
+func (st *memStore) read(ctx context.Context, name string) (*object, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	obj, ok := st.objects[name]
+	if !ok {
+		return nil, errNotExist
+	}
+	cp := *obj
+	return &cp, nil
+}
+
+func (st *memStore) write(ctx context.Context, name string, obj *object, gen int64) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if gen != anyGen {
+		var cur int64
+		if old, ok := st.objects[name]; ok {
+			cur = old.gen
+		}
+		if cur != gen {
+			return errPrecondition
+		}
+	}
+	st.lastGen++
+	cp := *obj
+	cp.gen = st.lastGen
+	st.objects[name] = &cp
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemStore(t *testing.T) {
+	testObjectStore(t, newMemStore())
+}
+
+func TestDirStore(t *testing.T) {
+	st := &dirStore{dir: t.TempDir()}
+	testObjectStore(t, st)
+
+	// Objects in subdirectories should also be supported.
+	ctx := context.Background()
+	obj := &object{data: []byte("a"), contentType: "text/plain", metadata: map[string]string{"k": "v"}}
+	if err := st.write(ctx, "dir/obj", obj, 0); err != nil {
+		t.Fatal("write failed: ", err)
+	}
+	if got, err := st.read(ctx, "dir/obj"); err != nil {
+		t.Error("read failed: ", err)
+	} else if string(got.data) != "a" || got.contentType != obj.contentType || got.metadata["k"] != "v" {
+		t.Errorf("read returned %+v; want %+v", got, obj)
+	}
+}
+
+// testObjectStore checks that st implements objectStore's generation preconditions.
+func testObjectStore(t *testing.T, st objectStore) {
+	ctx := context.Background()
+	if _, err := st.read(ctx, "obj"); err != errNotExist {
+		t.Errorf("read of missing object returned %v; want %v", err, errNotExist)
+	}
+	if err := st.write(ctx, "obj", &object{data: []byte("a")}, 1); err != errPrecondition {
+		t.Errorf("write with bad generation returned %v; want %v", err, errPrecondition)
+	}
+	if err := st.write(ctx, "obj", &object{data: []byte("a")}, 0); err != nil {
+		t.Error("write of new object failed: ", err)
+	}
+	if err := st.write(ctx, "obj", &object{data: []byte("b")}, 0); err != errPrecondition {
+		t.Errorf("write of existing object returned %v; want %v", err, errPrecondition)
+	}
+	obj, err := st.read(ctx, "obj")
+	if err != nil {
+		t.Fatal("read failed: ", err)
+	}
+	if string(obj.data) != "a" {
+		t.Errorf("read returned %q; want %q", obj.data, "a")
+	}
+	if err := st.write(ctx, "obj", &object{data: []byte("c")}, obj.gen); err != nil {
+		t.Error("write with matching generation failed: ", err)
+	}
+	if err := st.write(ctx, "obj", &object{data: []byte("d")}, anyGen); err != nil {
+		t.Error("unconditional write failed: ", err)
+	}
+}
+
+func TestUpdateObject(t *testing.T) {
+	ctx := context.Background()
+	st := newMemStore()
+
+	// Simulate a concurrent write the first time that fn is called.
+	var calls int
+	fn := func(old *object) (*object, error) {
+		calls++
+		if calls == 1 {
+			if err := st.write(ctx, "obj", &object{data: []byte("other")}, anyGen); err != nil {
+				t.Fatal("write failed: ", err)
+			}
+		}
+		var data string
+		if old != nil {
+			data = string(old.data)
+		}
+		return &object{data: []byte(data + "+")}, nil
+	}
+	if err := updateObject(ctx, st, "obj", fn); err != nil {
+		t.Fatal("updateObject failed: ", err)
+	}
+	if calls != 2 {
+		t.Errorf("fn called %d time(s); want 2", calls)
+	}
+	if obj, err := st.read(ctx, "obj"); err != nil {
+		t.Error("read failed: ", err)
+	} else if got, want := string(obj.data), "other+"; got != want {
+		t.Errorf("read returned %q; want %q", got, want)
+	}
+}
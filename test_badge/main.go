@@ -21,11 +21,15 @@ import (
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <output-path>\n"+
-			"Writes an SVG badge image to the supplied path.\n", os.Args[0])
+			"Writes an SVG or PNG badge image to the supplied path.\n", os.Args[0])
 		flag.PrintDefaults()
 	}
+	label := flag.String("label", "build", "Text for left side of badge")
+	palette := flag.String("palette", "github", "Color palette (github, shields, or high-contrast)")
+	style := flag.String("style", "flat", "Badge style (flat, flat-square, plastic, or for-the-badge)")
+	pngImage := flag.Bool("png", false, "Write PNG image instead of SVG")
 	report := flag.Bool("report", false, "Write report file with .html extension alongside image")
-	status := flag.String("status", "SUCCESS", "Build status (SUCCESS, FAILURE, INTERNAL_ERROR, or TIMEOUT)")
+	status := flag.String("status", "SUCCESS", "Build status (SUCCESS, FAILURE, INTERNAL_ERROR, TIMEOUT, or WORKING)")
 	flag.Parse()
 	if len(flag.Args()) != 1 {
 		flag.Usage()
@@ -37,6 +41,12 @@ func main() {
 		os.Exit(2)
 	}
 
+	cfg, err := watch.FakeBadgeConfig(*palette, *label, *style)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Bad badge config:", err)
+		os.Exit(2)
+	}
+
 	now := time.Now()
 	build := &cbpb.Build{
 		Status:     cbpb.Build_Status(st),
@@ -45,7 +55,10 @@ func main() {
 	}
 
 	if err := writeFile(flag.Arg(0), func(w io.Writer) error {
-		return watch.CreateBadge(w, build)
+		if *pngImage {
+			return watch.CreateBadgePNG(w, cfg, build)
+		}
+		return watch.CreateBadge(w, cfg, build)
 	}); err != nil {
 		fmt.Fprintln(os.Stderr, "Failed writing badge:", err)
 		os.Exit(1)
@@ -25,6 +25,7 @@ func main() {
 			"Sends an example build notification to the specified address.\n", os.Args[0])
 		flag.PrintDefaults()
 	}
+	event := flag.String("event", "", "Build event (BROKEN, STILL_FAILING, FIXED, or STILL_PASSING)")
 	flag.Parse()
 	if len(flag.Args()) != 1 {
 		flag.Usage()
@@ -61,7 +62,7 @@ func main() {
 			"REPO_NAME":    "repo-name",
 			"TRIGGER_NAME": "trigger-name",
 		},
-	})
+	}, watch.Event(*event))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed building email:", err)
 		os.Exit(1)
@@ -0,0 +1,44 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+// verdanaUnitsPerEm is the number of font units per em in Verdana.
+const verdanaUnitsPerEm = 2048
+
+// verdanaDefaultWidth is the advance width in font units used for characters
+// that aren't in verdanaWidths. It's a full em to err on the side of wider badges.
+const verdanaDefaultWidth = 2048
+
+// verdanaWidths contains advance widths in font units for printable ASCII characters in Verdana.
+// This is the same approach that shields.io uses to size badges without rendering text.
+var verdanaWidths = map[rune]int{
+	' ': 720, '!': 824, '"': 942, '#': 1716, '$': 1303, '%': 2218, '&': 1484, '\'': 550,
+	'(': 909, ')': 909, '*': 1303, '+': 1716, ',': 745, '-': 909, '.': 745, '/': 909,
+	'0': 1303, '1': 1303, '2': 1303, '3': 1303, '4': 1303,
+	'5': 1303, '6': 1303, '7': 1303, '8': 1303, '9': 1303,
+	':': 909, ';': 909, '<': 1716, '=': 1716, '>': 1716, '?': 1116, '@': 2048,
+	'A': 1401, 'B': 1405, 'C': 1430, 'D': 1577, 'E': 1294, 'F': 1178, 'G': 1587,
+	'H': 1540, 'I': 860, 'J': 929, 'K': 1415, 'L': 1145, 'M': 1722, 'N': 1533,
+	'O': 1612, 'P': 1241, 'Q': 1612, 'R': 1425, 'S': 1405, 'T': 1239, 'U': 1511,
+	'V': 1401, 'W': 2038, 'X': 1405, 'Y': 1237, 'Z': 1405,
+	'[': 909, '\\': 909, ']': 909, '^': 1716, '_': 1303, '`': 1303,
+	'a': 1229, 'b': 1276, 'c': 1067, 'd': 1276, 'e': 1220, 'f': 720, 'g': 1276,
+	'h': 1296, 'i': 562, 'j': 676, 'k': 1212, 'l': 562, 'm': 1992, 'n': 1296,
+	'o': 1243, 'p': 1276, 'q': 1276, 'r': 874, 's': 1067, 't': 807, 'u': 1296,
+	'v': 1212, 'w': 1675, 'x': 1212, 'y': 1212, 'z': 1051,
+	'{': 1300, '|': 909, '}': 1300, '~': 1716,
+}
+
+// textWidth returns the width in pixels of s when rendered in Verdana at size pixels.
+func textWidth(s string, size float64) float64 {
+	var units int
+	for _, ch := range s {
+		if w, ok := verdanaWidths[ch]; ok {
+			units += w
+		} else {
+			units += verdanaDefaultWidth
+		}
+	}
+	return float64(units) * size / verdanaUnitsPerEm
+}
@@ -0,0 +1,29 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTextWidth(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want float64 // expected width at 11px
+	}{
+		{"", 0},
+		{"build", 26.70},
+		{"success", 42.17},
+		{"failure", 34.71},
+		{"error", 27.31},
+		{"timeout", 42.58},
+		{"0123456789", 69.99},
+		{"é", 11}, // not in table
+	} {
+		if got := textWidth(tc.s, 11); math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("textWidth(%q, 11) = %0.2f; want %0.2f", tc.s, got, tc.want)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
@@ -16,7 +18,7 @@ import (
 
 // WatchBuilds is a Cloud Function that processes Pub/Sub messages sent by Cloud Build.
 func WatchBuilds(ctx context.Context, msg *pubsub.Message) error {
-	cfg, err := loadConfig()
+	cfg, err := loadConfig(ctx)
 	if err != nil {
 		return fmt.Errorf("failed loading config: %v", err)
 	}
@@ -31,28 +33,29 @@ func WatchBuilds(ctx context.Context, msg *pubsub.Message) error {
 
 	log.Printf("Got message about build %s with status %s", build.Id, build.Status)
 
-	if err := cfg.checkEmail(&build); err != nil {
-		log.Print("Not sending email: ", err)
-	} else if err := sendEmail(ctx, cfg, &build); err != nil {
-		log.Print("Failed sending email: ", err)
+	ev, err := updateState(ctx, cfg, &build)
+	if err != nil {
+		log.Print("Failed updating state: ", err)
+		if cfg.redeliver {
+			return fmt.Errorf("failed updating state: %v", err)
+		}
 	}
-
-	if err := cfg.checkBadge(&build); err != nil {
-		log.Print("Not writing badge: ", err)
-	} else if err := writeBadge(ctx, cfg, &build); err != nil {
-		log.Print("Failed writing badge: ", err)
+	if err := runNotifiers(ctx, cfg.notifiers(), &build, ev); err != nil && cfg.redeliver {
+		// Returning an error causes Pub/Sub to redeliver the message if the function
+		// was deployed with retries enabled.
+		return err
 	}
-
 	return nil
 }
 
 const (
 	// Substitution names to pass to buildSub:
 	// https://cloud.google.com/build/docs/configuring-builds/substitute-variable-values
-	branchSub      = "BRANCH_NAME"
-	commitSub      = "COMMIT_SHA"
-	repoSub        = "REPO_NAME"
-	triggerNameSub = "TRIGGER_NAME"
+	branchSub       = "BRANCH_NAME"
+	commitSub       = "COMMIT_SHA"
+	repoSub         = "REPO_NAME"
+	repoFullNameSub = "REPO_FULL_NAME" // "owner/repo", only set for GitHub triggers
+	triggerNameSub  = "TRIGGER_NAME"
 )
 
 // buildSub returns the named value from b's Substitutions map.
@@ -63,3 +66,51 @@ func buildSub(b *cbpb.Build, name, def string) string {
 	}
 	return def
 }
+
+// buildData contains information about a build for use in notification messages.
+type buildData struct {
+	BuildID     string
+	LogURL      string
+	TriggerID   string
+	TriggerName string
+	TriggerURL  string
+	Status      string
+	Repo        string
+	Commit      string
+	Branch      string
+	Start       string
+	End         string
+	Duration    string
+}
+
+// newBuildData returns a buildData describing b. Times are formatted in tz.
+func newBuildData(b *cbpb.Build, tz *time.Location) *buildData {
+	const timeFmt = time.RFC1123Z // "Mon, 02 Jan 2006 15:04:05 -0700"
+	start := b.StartTime.AsTime()
+	end := b.FinishTime.AsTime()
+	return &buildData{
+		BuildID:     b.Id,
+		LogURL:      b.LogUrl,
+		TriggerID:   b.BuildTriggerId,
+		TriggerName: buildSub(b, triggerNameSub, ""),
+		TriggerURL:  "https://console.cloud.google.com/cloud-build/triggers/edit/" + b.BuildTriggerId,
+		Status:      b.Status.String(),
+		Repo:        buildSub(b, repoSub, ""),
+		Commit:      buildSub(b, commitSub, ""),
+		Branch:      buildSub(b, branchSub, ""),
+		Start:       start.In(tz).Format(timeFmt),
+		End:         end.In(tz).Format(timeFmt),
+		Duration:    formatDuration(end.Sub(start)),
+	}
+}
+
+// buildSummary returns a one-line summary of b, e.g. "[my-project] my-trigger FAILURE (build 1234)".
+// If ev is EventFixed, "fixed" is used in place of the status.
+func buildSummary(b *cbpb.Build, ev Event) string {
+	status := b.Status.String()
+	if ev == EventFixed {
+		status = "fixed"
+	}
+	return fmt.Sprintf("[%s] %s %s (build %s)", b.ProjectId,
+		buildSub(b, triggerNameSub, "[unknown]"), status, strings.Split(b.Id, "-")[0])
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+const (
+	// webhookSigHeader is the name of the header containing the payload's signature.
+	webhookSigHeader = "X-Signature"
+	// webhookSigPrefix precedes the hex-encoded HMAC-SHA256 digest in webhookSigHeader.
+	webhookSigPrefix = "sha256="
+)
+
+// webhookRetryDelay is the delay before retrying a failed webhook request.
+// It is doubled after each failure. It is a variable so it can be shortened by tests.
+var webhookRetryDelay = time.Second
+
+// webhookNotifier implements notifier by POSTing JSON build summaries to a URL.
+type webhookNotifier struct{ cfg *Config }
+
+func (n *webhookNotifier) name() string                        { return n.cfg.notifierName("webhook") }
+func (n *webhookNotifier) check(b *cbpb.Build, ev Event) error { return n.cfg.checkWebhook(b, ev) }
+func (n *webhookNotifier) notify(ctx context.Context, b *cbpb.Build, ev Event) error {
+	return postWebhook(ctx, n.cfg, b, ev)
+}
+
+// webhookPayload is the JSON object POSTed by postWebhook.
+type webhookPayload struct {
+	ID          string     `json:"id"`
+	ProjectID   string     `json:"projectId"`
+	Status      string     `json:"status"`
+	Event       Event      `json:"event,omitempty"`
+	TriggerID   string     `json:"triggerId,omitempty"`
+	TriggerName string     `json:"triggerName,omitempty"`
+	Repo        string     `json:"repo,omitempty"`
+	Commit      string     `json:"commit,omitempty"`
+	Branch      string     `json:"branch,omitempty"`
+	LogURL      string     `json:"logUrl,omitempty"`
+	StartTime   *time.Time `json:"startTime,omitempty"`
+	FinishTime  *time.Time `json:"finishTime,omitempty"`
+	DurationSec float64    `json:"durationSec,omitempty"` // only set if started and finished
+}
+
+// postWebhook POSTs a JSON summary of build and ev to cfg.webhookURL.
+// cfg.checkWebhook must be called first to check that the request should actually be sent.
+func postWebhook(ctx context.Context, cfg *Config, build *cbpb.Build, ev Event) error {
+	payload := webhookPayload{
+		ID:          build.Id,
+		ProjectID:   build.ProjectId,
+		Status:      build.Status.String(),
+		Event:       ev,
+		TriggerID:   build.BuildTriggerId,
+		TriggerName: buildSub(build, triggerNameSub, ""),
+		Repo:        buildSub(build, repoSub, ""),
+		Commit:      buildSub(build, commitSub, ""),
+		Branch:      buildSub(build, branchSub, ""),
+		LogURL:      build.LogUrl,
+	}
+	if build.StartTime != nil {
+		start := build.StartTime.AsTime()
+		payload.StartTime = &start
+	}
+	if build.FinishTime != nil {
+		end := build.FinishTime.AsTime()
+		payload.FinishTime = &end
+	}
+	if payload.StartTime != nil && payload.FinishTime != nil {
+		payload.DurationSec = payload.FinishTime.Sub(*payload.StartTime).Seconds()
+	}
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	log.Print("Posting webhook request to ", cfg.webhookURL)
+	client := http.Client{Timeout: cfg.webhookTimeout}
+	return retry(ctx, cfg.webhookRetries, webhookRetryDelay, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.webhookURL, bytes.NewReader(body))
+		if err != nil {
+			return &permanentError{err}
+		}
+		for n, v := range cfg.webhookHeaders {
+			req.Header.Set(n, v)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if cfg.webhookSecret != "" {
+			req.Header.Set(webhookSigHeader, signWebhook(cfg.webhookSecret, body))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("got %v: %q", resp.Status, strings.TrimSpace(string(msg)))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return &permanentError{err}
+		}
+		return err
+	})
+}
+
+// signWebhook returns the webhookSigHeader value for body signed with secret.
+// Receivers should compute the same value and compare it using hmac.Equal.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return webhookSigPrefix + hex.EncodeToString(mac.Sum(nil))
+}
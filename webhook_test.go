@@ -0,0 +1,131 @@
+// Copyright 2021 Daniel Erat.
+// All rights reserved.
+
+package watch
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cbpb "google.golang.org/genproto/googleapis/devtools/cloudbuild/v1"
+)
+
+func TestPostWebhook(t *testing.T) {
+	const secret = "my-secret"
+
+	origDelay := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = origDelay }()
+
+	var reqs int
+	var got webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqs++
+		if reqs == 1 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		if v := req.Header.Get("X-Custom"); v != "custom-value" {
+			t.Errorf("Got X-Custom header %q; want %q", v, "custom-value")
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error("Failed reading request: ", err)
+		}
+		sig, want := req.Header.Get(webhookSigHeader), signWebhook(secret, body)
+		if !hmac.Equal([]byte(sig), []byte(want)) {
+			t.Errorf("Got signature %q; want %q", sig, want)
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("Failed unmarshaling %q: %v", body, err)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &Config{
+		webhookURL:     srv.URL,
+		webhookSecret:  secret,
+		webhookHeaders: map[string]string{"X-Custom": "custom-value"},
+		webhookTimeout: 10 * time.Second,
+		webhookRetries: 2,
+	}
+	build := &cbpb.Build{
+		Id:             "1234-5678",
+		ProjectId:      "my-project",
+		BuildTriggerId: "trigger-id",
+		Status:         cbpb.Build_FAILURE,
+		StartTime:      makeTimestamp("2021-12-11T19:42:31Z"),
+		FinishTime:     makeTimestamp("2021-12-11T20:04:51Z"),
+		Substitutions:  map[string]string{triggerNameSub: "my-trigger"},
+	}
+	if err := postWebhook(context.Background(), cfg, build, EventNone); err != nil {
+		t.Fatal("postWebhook failed: ", err)
+	}
+	if reqs != 2 {
+		t.Errorf("Server got %d request(s); want 2", reqs)
+	}
+	if got.ID != build.Id || got.Status != "FAILURE" || got.TriggerName != "my-trigger" ||
+		got.DurationSec != 1340 {
+		t.Errorf("Got payload %+v", got)
+	}
+}
+
+func TestPostWebhook_NotStarted(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			t.Error("Failed decoding request: ", err)
+		}
+	}))
+	defer srv.Close()
+
+	// Builds that are cancelled while queued have a finish time but no start time.
+	cfg := &Config{webhookURL: srv.URL, webhookTimeout: 10 * time.Second}
+	build := &cbpb.Build{
+		Id:         "1234-5678",
+		Status:     cbpb.Build_CANCELLED,
+		FinishTime: makeTimestamp("2021-12-11T20:04:51Z"),
+	}
+	if err := postWebhook(context.Background(), cfg, build, EventNone); err != nil {
+		t.Fatal("postWebhook failed: ", err)
+	}
+	for _, k := range []string{"startTime", "durationSec"} {
+		if v, ok := got[k]; ok {
+			t.Errorf("Payload unexpectedly has %v %v", k, v)
+		}
+	}
+	if got, want := got["finishTime"], "2021-12-11T20:04:51Z"; got != want {
+		t.Errorf("Got finishTime %v; want %q", got, want)
+	}
+}
+
+func TestPostWebhook_PermanentError(t *testing.T) {
+	var reqs int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqs++
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	cfg := &Config{webhookURL: srv.URL, webhookTimeout: 10 * time.Second, webhookRetries: 2}
+	if err := postWebhook(context.Background(), cfg, &cbpb.Build{}, EventNone); err == nil {
+		t.Error("postWebhook unexpectedly succeeded")
+	}
+	if reqs != 1 {
+		t.Errorf("Server got %d requests; want 1", reqs)
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// Computed using "echo -n 'hello' | openssl dgst -sha256 -hmac key".
+	const want = "sha256=9307b3b915efb5171ff14d8cb55fbcc798c6c0ef1456d66ded1a6aa723a58b7b"
+	if got := signWebhook("key", []byte("hello")); got != want {
+		t.Errorf("signWebhook() = %q; want %q", got, want)
+	}
+}